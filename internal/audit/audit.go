@@ -0,0 +1,422 @@
+// Package audit compares the grants of existing accounts with the minimum grants needed by the
+// queries they run.
+package audit
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+)
+
+// AccountAudit holds the audit results for an account
+type AccountAudit struct {
+	Account tester.Account
+	// Queries is the number of queries in the logs run by the account
+	Queries int
+	// Grants are the grants the account has in the audited server
+	Grants []tester.Grant
+	// Roles are the grants of the roles granted to the account, directly or through other roles
+	Roles []RoleGrants
+	// Required are the minimum grants needed by the account queries
+	Required []tester.Grant
+	// Excess are the privileges not needed by any of the account queries, or granted at a
+	// wider level than needed
+	Excess []tester.Grant
+	// RoleExcess are the excess privileges the account gets through its roles
+	RoleExcess []RoleGrants
+	// Missing are the required grants the account doesn't have
+	Missing []tester.Grant
+	// Statements are the REVOKE (and GRANT, when a partially used ALL PRIVILEGES or a privilege
+	// granted at a wider level than needed is revoked) statements to remove the excess privileges.
+	// The privileges granted through a role are revoked from the role.
+	Statements []string
+}
+
+// RoleGrants holds the grants of a role
+type RoleGrants struct {
+	// Role is the role as it can be used in GRANT and REVOKE, like `app_read`@`%`
+	Role   string
+	Grants []tester.Grant
+}
+
+// ParseAccount parses an account in user@host format. The host defaults to %.
+func ParseAccount(s string) (tester.Account, error) {
+	s = strings.TrimSpace(s)
+	user, host := s, "%"
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		user, host = s[:i], s[i+1:]
+	}
+	user, host = unquote(user), unquote(host)
+	if user == "" || host == "" {
+		return tester.Account{}, fmt.Errorf("invalid account %q. The format is user@host", s)
+	}
+	return tester.Account{User: user, Host: host}, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '`' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// Matches returns true if a user connecting from a host, as read from the logs, authenticates
+// as the account. The account host can have the % and _ wildcards.
+func Matches(account tester.Account, user, host string) bool {
+	if account.User != user {
+		return false
+	}
+	re := &strings.Builder{}
+	re.WriteString("(?i)^")
+	for _, c := range account.Host {
+		switch c {
+		case '%':
+			re.WriteString(".*")
+		case '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String()).MatchString(host)
+}
+
+// Queries returns the testing cases run by the account
+func Queries(account tester.Account, testCases []*tester.TestingCase) []*tester.TestingCase {
+	queries := []*tester.TestingCase{}
+	for _, tc := range testCases {
+		for _, u := range tc.Users {
+			if Matches(account, u.User, u.Host) {
+				queries = append(queries, tc)
+				break
+			}
+		}
+	}
+	return queries
+}
+
+// ShowGrants returns the grants of the account in the audited server and the grants of the
+// roles granted to it, directly or through other roles, since its queries can use them. It only
+// needs read access.
+func ShowGrants(db *sql.DB, account tester.Account) ([]tester.Grant, []RoleGrants, error) {
+	grants, roles, err := showGrants(db, account.String())
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Cannot get the grants for %s", account)
+	}
+
+	roleGrants := []RoleGrants{}
+	seen := map[string]bool{account.String(): true}
+	for len(roles) > 0 {
+		role := roles[0]
+		roles = roles[1:]
+		if seen[role] {
+			continue
+		}
+		seen[role] = true
+		g, more, err := showGrants(db, role)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Cannot get the grants for the role %s", role)
+		}
+		roleGrants = append(roleGrants, RoleGrants{Role: role, Grants: g})
+		roles = append(roles, more...)
+	}
+	return grants, roleGrants, nil
+}
+
+// showGrants returns the privileges granted to the grantee and the roles granted to it
+func showGrants(db *sql.DB, grantee string) ([]tester.Grant, []string, error) {
+	rows, err := db.Query(fmt.Sprintf("SHOW GRANTS FOR %s", grantee))
+	if err != nil {
+		return nil, nil, err
+	}
+	lines, roles := []string{}, []string{}
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if r, ok := grantedRoles(line); ok {
+			roles = append(roles, r...)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	grants, err := tester.ParseGrants(strings.NewReader(strings.Join(lines, "\n")))
+	return grants, roles, err
+}
+
+var (
+	roleGrantRe = regexp.MustCompile(`(?is)^GRANT\s+(.+?)\s+TO\s+`)
+	onRe        = regexp.MustCompile(`(?i)\sON\s`)
+)
+
+// grantedRoles returns the roles in a roles grant, like GRANT `r1`@`%`,`r2`@`%` TO `app`@`%`,
+// as they can be used in SHOW GRANTS FOR. It returns false if the line is not a roles grant.
+func grantedRoles(line string) ([]string, bool) {
+	m := roleGrantRe.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil || onRe.MatchString(line) {
+		return nil, false
+	}
+	roles := []string{}
+	var quote rune
+	role := &strings.Builder{}
+	for _, c := range m[1] {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '`' || c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			roles = append(roles, strings.TrimSpace(role.String()))
+			role.Reset()
+			continue
+		}
+		role.WriteRune(c)
+	}
+	return append(roles, strings.TrimSpace(role.String())), true
+}
+
+// Compare compares the grants of the account and of its roles with the required grants and
+// returns the audit results, including the statements to revoke the excess privileges.
+func Compare(account tester.Account, grants []tester.Grant, roles []RoleGrants, required []tester.Grant) *AccountAudit {
+	a := &AccountAudit{Account: account, Grants: grants, Roles: roles, Required: required}
+
+	excess, regrant := excessPrivileges(grants, required)
+	a.Excess = excess
+	a.Statements = revokeStatements(account.String(), excess, regrant)
+
+	// All the grants the account can use
+	all := append([]tester.Grant{}, grants...)
+	for _, role := range roles {
+		all = append(all, role.Grants...)
+		excess, regrant := excessPrivileges(role.Grants, required)
+		if len(excess) > 0 {
+			a.RoleExcess = append(a.RoleExcess, RoleGrants{Role: role.Role, Grants: excess})
+		}
+		a.Statements = append(a.Statements, revokeStatements(role.Role, excess, regrant)...)
+	}
+
+	for _, req := range required {
+		missing := []string{}
+		for _, priv := range req.Privileges {
+			if !isGranted(req, priv, all) {
+				missing = append(missing, priv)
+			}
+		}
+		if len(missing) > 0 {
+			a.Missing = append(a.Missing, tester.Grant{Privileges: missing, Database: req.Database,
+				Table: req.Table, Columns: req.Columns})
+		}
+	}
+
+	return a
+}
+
+// excessPrivileges returns the granted privileges not needed by the required grants, or granted
+// at a wider level than needed, and the required grants to grant back after revoking them
+func excessPrivileges(grants, required []tester.Grant) ([]tester.Grant, []tester.Grant) {
+	var excessGrants []tester.Grant
+	// regrant are the required grants covered by a partially used ALL PRIVILEGES
+	regrant := []tester.Grant{}
+
+	for _, grant := range grants {
+		excess := []string{}
+		for _, priv := range grant.Privileges {
+			if priv == "USAGE" {
+				continue
+			}
+			if priv == "ALL PRIVILEGES" {
+				if used := coveredBy(grant, required); len(used) > 0 {
+					// Revoke ALL and grant back only the privileges in use
+					excessGrants = append(excessGrants, tester.Grant{Privileges: []string{priv},
+						Database: grant.Database, Table: grant.Table, Columns: grant.Columns})
+					regrant = append(regrant, used...)
+					continue
+				}
+			}
+			if !isRequired(grant, priv, required) {
+				excess = append(excess, priv)
+				continue
+			}
+			if narrower := overScoped(grant, priv, required); len(narrower) > 0 {
+				// Revoke the privilege and grant it back only at the levels in use
+				excess = append(excess, priv)
+				regrant = append(regrant, narrower...)
+			}
+		}
+		if len(excess) > 0 {
+			excessGrants = append(excessGrants, tester.Grant{Privileges: excess, Database: grant.Database,
+				Table: grant.Table, Columns: grant.Columns})
+		}
+	}
+	return excessGrants, regrant
+}
+
+// revokeStatements returns the statements to revoke the excess privileges from the grantee and
+// to grant back the privileges in use
+func revokeStatements(grantee string, excess, regrant []tester.Grant) []string {
+	var statements []string
+	for _, g := range excess {
+		statements = append(statements, fmt.Sprintf("REVOKE %s ON %s FROM %s;", strings.Join(g.Privileges, ", "),
+			g.On(), grantee))
+	}
+	for _, g := range mergeLevels(regrant) {
+		statements = append(statements, fmt.Sprintf("GRANT %s TO %s;", g, grantee))
+	}
+	return statements
+}
+
+// isRequired returns true if any of the required grants needs the privilege granted by grant
+func isRequired(grant tester.Grant, priv string, required []tester.Grant) bool {
+	for _, req := range required {
+		if covers(grant, req) && contains(req.Privileges, priv) {
+			return true
+		}
+	}
+	return false
+}
+
+// overScoped returns the required grants of the privilege, at levels narrower than the grant
+// level, if none of the required grants needs the privilege at the grant level, like
+// SELECT ON *.* when only SELECT ON sakila.actor is needed
+func overScoped(grant tester.Grant, priv string, required []tester.Grant) []tester.Grant {
+	narrower := []tester.Grant{}
+	for _, req := range required {
+		if !covers(grant, req) || !contains(req.Privileges, priv) {
+			continue
+		}
+		if covers(req, grant) {
+			return nil
+		}
+		req.Privileges = []string{priv}
+		narrower = append(narrower, req)
+	}
+	return narrower
+}
+
+// mergeLevels merges the privileges of the grants having the same level
+func mergeLevels(grants []tester.Grant) []tester.Grant {
+	merged := []tester.Grant{}
+	for _, g := range grants {
+		i := 0
+		for ; i < len(merged); i++ {
+			if merged[i].On() == g.On() && strings.Join(merged[i].Columns, ",") == strings.Join(g.Columns, ",") {
+				break
+			}
+		}
+		if i == len(merged) {
+			merged = append(merged, tester.Grant{Database: g.Database, Table: g.Table, Routine: g.Routine,
+				Columns: g.Columns})
+		}
+		for _, priv := range g.Privileges {
+			if !contains(merged[i].Privileges, priv) {
+				merged[i].Privileges = append(merged[i].Privileges, priv)
+			}
+		}
+	}
+	return merged
+}
+
+// isGranted returns true if any of the grants covers the privilege needed by req
+func isGranted(req tester.Grant, priv string, grants []tester.Grant) bool {
+	for _, grant := range grants {
+		if !covers(grant, req) {
+			continue
+		}
+		if contains(grant.Privileges, priv) || (contains(grant.Privileges, "ALL PRIVILEGES") && priv != "GRANT OPTION") {
+			return true
+		}
+	}
+	return false
+}
+
+// coveredBy returns the required grants, except GRANT OPTION, at levels covered by grant
+func coveredBy(grant tester.Grant, required []tester.Grant) []tester.Grant {
+	covered := []tester.Grant{}
+	for _, req := range required {
+		if !covers(grant, req) {
+			continue
+		}
+		privs := []string{}
+		for _, priv := range req.Privileges {
+			if priv != "GRANT OPTION" && priv != "USAGE" {
+				privs = append(privs, priv)
+			}
+		}
+		if len(privs) > 0 {
+			req.Privileges = privs
+			covered = append(covered, req)
+		}
+	}
+	return covered
+}
+
+// covers returns true if the level of grant includes the level of req:
+// *.* includes everything, db.* includes the tables in db and a table includes its columns.
+func covers(grant, req tester.Grant) bool {
+	switch {
+	case grant.Database == "":
+		return true
+	case grant.Database != req.Database:
+		return false
+	case grant.Table == "":
+		return true
+	case grant.Table != req.Table, grant.Routine != req.Routine:
+		return false
+	case len(grant.Columns) == 0:
+		return true
+	case len(req.Columns) == 0:
+		return false
+	}
+	for _, col := range req.Columns {
+		if !contains(grant.Columns, col) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if strings.EqualFold(i, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accounts returns the distinct accounts that ran the queries, sorted by user and host
+func Accounts(testCases []*tester.TestingCase) []tester.Account {
+	seen := map[tester.Account]bool{}
+	accounts := []tester.Account{}
+	for _, tc := range testCases {
+		for _, u := range tc.Users {
+			if !seen[u] {
+				seen[u] = true
+				accounts = append(accounts, u)
+			}
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].User != accounts[j].User {
+			return accounts[i].User < accounts[j].User
+		}
+		return accounts[i].Host < accounts[j].Host
+	})
+	return accounts
+}
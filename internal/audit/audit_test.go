@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+	tu "github.com/Percona-Lab/minimum_permissions/internal/testutils"
+)
+
+func TestParseAccount(t *testing.T) {
+	a, err := ParseAccount("app@10.%")
+	tu.Ok(t, err)
+	tu.Equals(t, a, tester.Account{User: "app", Host: "10.%"})
+
+	a, err = ParseAccount("'app'@'localhost'")
+	tu.Ok(t, err)
+	tu.Equals(t, a, tester.Account{User: "app", Host: "localhost"})
+
+	a, err = ParseAccount("app")
+	tu.Ok(t, err)
+	tu.Equals(t, a, tester.Account{User: "app", Host: "%"})
+
+	_, err = ParseAccount("@localhost")
+	tu.NotOk(t, err)
+}
+
+func TestMatches(t *testing.T) {
+	tu.Assert(t, Matches(tester.Account{User: "app", Host: "%"}, "app", "localhost"), "% matches any host")
+	tu.Assert(t, Matches(tester.Account{User: "app", Host: "10.0.%"}, "app", "10.0.1.5"), "10.0.% matches 10.0.1.5")
+	tu.Assert(t, !Matches(tester.Account{User: "app", Host: "10.0.%"}, "app", "10.1.1.5"), "10.0.% doesn't match 10.1.1.5")
+	tu.Assert(t, !Matches(tester.Account{User: "app", Host: "%"}, "web", "localhost"), "different user")
+	tu.Assert(t, !Matches(tester.Account{User: "app", Host: "10.0.0.1"}, "app", "10.0.0.10"), "no prefix match")
+}
+
+func TestQueries(t *testing.T) {
+	testCases := []*tester.TestingCase{
+		{Query: "SELECT 1", Users: []tester.Account{{User: "app", Host: "10.0.0.1"}}},
+		{Query: "SELECT 2", Users: []tester.Account{{User: "web", Host: "10.0.0.1"}, {User: "app", Host: "10.0.0.2"}}},
+		{Query: "SELECT 3", Users: []tester.Account{{User: "web", Host: "10.0.0.1"}}},
+		{Query: "SELECT 4"},
+	}
+	got := Queries(tester.Account{User: "app", Host: "10.%"}, testCases)
+	tu.Equals(t, got, testCases[:2])
+
+	tu.Equals(t, Accounts(testCases), []tester.Account{
+		{User: "app", Host: "10.0.0.1"}, {User: "app", Host: "10.0.0.2"}, {User: "web", Host: "10.0.0.1"},
+	})
+}
+
+func TestCompare(t *testing.T) {
+	account := tester.Account{User: "app", Host: "%"}
+	grants := []tester.Grant{
+		{Privileges: []string{"USAGE", "PROCESS", "SUPER"}},
+		{Privileges: []string{"SELECT", "INSERT", "DELETE"}, Database: "sakila"},
+		{Privileges: []string{"ALL PRIVILEGES"}, Database: "shop"},
+		{Privileges: []string{"ALL PRIVILEGES"}, Database: "old"},
+	}
+	required := []tester.Grant{
+		{Privileges: []string{"PROCESS"}},
+		{Privileges: []string{"SELECT"}, Database: "sakila", Table: "actor"},
+		{Privileges: []string{"SELECT", "UPDATE"}, Database: "shop", Table: "orders"},
+		{Privileges: []string{"SELECT"}, Database: "web"},
+	}
+
+	a := Compare(account, grants, nil, required)
+	tu.Equals(t, a.Excess, []tester.Grant{
+		{Privileges: []string{"SUPER"}},
+		{Privileges: []string{"SELECT", "INSERT", "DELETE"}, Database: "sakila"},
+		{Privileges: []string{"ALL PRIVILEGES"}, Database: "shop"},
+		{Privileges: []string{"ALL PRIVILEGES"}, Database: "old"},
+	})
+	tu.Equals(t, a.Missing, []tester.Grant{{Privileges: []string{"SELECT"}, Database: "web"}})
+	tu.Equals(t, a.Statements, []string{
+		"REVOKE SUPER ON *.* FROM 'app'@'%';",
+		"REVOKE SELECT, INSERT, DELETE ON `sakila`.* FROM 'app'@'%';",
+		"REVOKE ALL PRIVILEGES ON `shop`.* FROM 'app'@'%';",
+		"REVOKE ALL PRIVILEGES ON `old`.* FROM 'app'@'%';",
+		"GRANT SELECT ON `sakila`.`actor` TO 'app'@'%';",
+		"GRANT SELECT, UPDATE ON `shop`.`orders` TO 'app'@'%';",
+	})
+}
+
+func TestCompareOverScoped(t *testing.T) {
+	account := tester.Account{User: "app", Host: "%"}
+	grants := []tester.Grant{
+		{Privileges: []string{"SELECT", "INSERT", "PROCESS"}},
+		{Privileges: []string{"UPDATE"}, Database: "sakila", Table: "film"},
+	}
+	required := []tester.Grant{
+		{Privileges: []string{"PROCESS"}},
+		{Privileges: []string{"SELECT", "INSERT"}, Database: "sakila", Table: "actor"},
+		{Privileges: []string{"SELECT"}, Database: "world"},
+		{Privileges: []string{"UPDATE"}, Database: "sakila", Table: "film"},
+	}
+
+	a := Compare(account, grants, nil, required)
+	tu.Equals(t, a.Excess, []tester.Grant{{Privileges: []string{"SELECT", "INSERT"}}})
+	tu.Equals(t, len(a.Missing), 0)
+	tu.Equals(t, a.Statements, []string{
+		"REVOKE SELECT, INSERT ON *.* FROM 'app'@'%';",
+		"GRANT SELECT, INSERT ON `sakila`.`actor` TO 'app'@'%';",
+		"GRANT SELECT ON `world`.* TO 'app'@'%';",
+	})
+
+	// The privilege is needed at the granted level by one of the queries
+	required = append(required, tester.Grant{Privileges: []string{"SELECT"}})
+	a = Compare(account, grants, nil, required)
+	tu.Equals(t, a.Excess, []tester.Grant{{Privileges: []string{"INSERT"}}})
+}
+
+func TestCompareRoles(t *testing.T) {
+	account := tester.Account{User: "app", Host: "%"}
+	grants := []tester.Grant{{Privileges: []string{"USAGE"}}}
+	roles := []RoleGrants{
+		{Role: "`app_read`@`%`", Grants: []tester.Grant{{Privileges: []string{"SELECT", "PROCESS"}}}},
+		{Role: "`app_write`@`%`", Grants: []tester.Grant{{Privileges: []string{"INSERT"}, Database: "sakila"}}},
+	}
+	required := []tester.Grant{
+		{Privileges: []string{"SELECT"}, Database: "sakila", Table: "actor"},
+		{Privileges: []string{"INSERT"}, Database: "sakila"},
+	}
+
+	// The privileges granted only through a role are revoked from the role
+	a := Compare(account, grants, roles, required)
+	tu.Equals(t, len(a.Excess), 0)
+	tu.Equals(t, a.RoleExcess, []RoleGrants{
+		{Role: "`app_read`@`%`", Grants: []tester.Grant{{Privileges: []string{"SELECT", "PROCESS"}}}},
+	})
+	tu.Equals(t, len(a.Missing), 0)
+	tu.Equals(t, a.Statements, []string{
+		"REVOKE SELECT, PROCESS ON *.* FROM `app_read`@`%`;",
+		"GRANT SELECT ON `sakila`.`actor` TO `app_read`@`%`;",
+	})
+}
+
+func TestGrantedRoles(t *testing.T) {
+	roles, ok := grantedRoles("GRANT `app_read`@`%`,`app,write`@`%` TO `app`@`%` WITH ADMIN OPTION")
+	tu.Assert(t, ok, "roles grant not detected")
+	tu.Equals(t, roles, []string{"`app_read`@`%`", "`app,write`@`%`"})
+
+	roles, ok = grantedRoles("GRANT `reporting` TO `app`@`%`")
+	tu.Assert(t, ok, "MariaDB roles grant not detected")
+	tu.Equals(t, roles, []string{"`reporting`"})
+
+	_, ok = grantedRoles("GRANT SELECT ON `sakila`.* TO `app`@`%`")
+	tu.Assert(t, !ok, "privileges grant detected as a roles grant")
+}
+
+func TestCovers(t *testing.T) {
+	tu.Assert(t, covers(tester.Grant{}, tester.Grant{Database: "db", Table: "t"}), "global covers tables")
+	tu.Assert(t, covers(tester.Grant{Database: "db"}, tester.Grant{Database: "db", Table: "t"}), "schema covers its tables")
+	tu.Assert(t, !covers(tester.Grant{Database: "db"}, tester.Grant{}), "schema doesn't cover global")
+	tu.Assert(t, !covers(tester.Grant{Database: "db", Table: "t"}, tester.Grant{Database: "db", Table: "u"}), "other table")
+	tu.Assert(t, covers(tester.Grant{Database: "db", Table: "t", Columns: []string{"a", "b"}},
+		tester.Grant{Database: "db", Table: "t", Columns: []string{"a"}}), "columns subset")
+	tu.Assert(t, !covers(tester.Grant{Database: "db", Table: "t", Columns: []string{"a"}},
+		tester.Grant{Database: "db", Table: "t"}), "columns don't cover the table")
+}
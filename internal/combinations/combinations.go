@@ -0,0 +1,179 @@
+package combinations
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Iterator generates all the combinations of k elements out of n in increasing order of
+// their total weight, one at a time, without holding them in memory. Combinations having the
+// same total weight are generated in a fixed order, so a search can be resumed by index.
+// Example: weights [1, 1, 3], k=2 generates [0 1] (2), [0 2] (4), [1 2] (4)
+type Iterator struct {
+	// order has the elements indexes sorted by weight. groups are the [start, end) positions in
+	// order of the elements having the same weight
+	order  []int
+	groups [][2]int
+	// parts has, for each total weight, how many elements are taken from each group, sorted by
+	// total weight. counts has the number of combinations of each part
+	parts  [][]int
+	counts []uint64
+	index  uint64
+	total  uint64
+	next   uint64
+}
+
+// New returns a new combinations iterator for elements having the specified weights, positioned
+// before the first combination. If all the weights are equal, the combinations are generated in
+// lexicographic order.
+func New(weights []int, k int) *Iterator {
+	it := &Iterator{order: make([]int, len(weights))}
+	for i := range it.order {
+		it.order[i] = i
+	}
+	sort.SliceStable(it.order, func(i, j int) bool { return weights[it.order[i]] < weights[it.order[j]] })
+
+	groupWeights := []int{}
+	for i, e := range it.order {
+		if i == 0 || weights[e] != groupWeights[len(groupWeights)-1] {
+			it.groups = append(it.groups, [2]int{i, i})
+			groupWeights = append(groupWeights, weights[e])
+		}
+		it.groups[len(it.groups)-1][1] = i + 1
+	}
+
+	if k >= 0 && k <= len(weights) {
+		it.addParts(make([]int, len(it.groups)), 0, k)
+	}
+	partWeight := func(part []int) int {
+		w := 0
+		for g, c := range part {
+			w += c * groupWeights[g]
+		}
+		return w
+	}
+	sort.SliceStable(it.parts, func(i, j int) bool { return partWeight(it.parts[i]) < partWeight(it.parts[j]) })
+
+	for _, part := range it.parts {
+		count := uint64(1)
+		for g, c := range part {
+			count *= Count(it.groups[g][1]-it.groups[g][0], c)
+		}
+		it.counts = append(it.counts, count)
+		it.total += count
+	}
+	return it
+}
+
+// addParts adds all the ways to take k elements from the groups starting at group g, taking
+// first as many elements as possible from the lighter groups
+func (it *Iterator) addParts(part []int, g, k int) {
+	if g == len(it.groups) {
+		if k == 0 {
+			it.parts = append(it.parts, append([]int{}, part...))
+		}
+		return
+	}
+	size := it.groups[g][1] - it.groups[g][0]
+	for c := size; c >= 0; c-- {
+		if c > k {
+			continue
+		}
+		part[g] = c
+		it.addParts(part, g+1, k-c)
+	}
+	part[g] = 0
+}
+
+// Total returns the number of combinations the iterator will generate
+func (it *Iterator) Total() uint64 {
+	return it.total
+}
+
+// Seek positions the iterator so the next call to Next returns the combination number index
+// (starting from 0). It is used to resume an interrupted search.
+func (it *Iterator) Seek(index uint64) error {
+	if index >= it.total {
+		return fmt.Errorf("invalid combination index %d. There are only %d combinations", index, it.total)
+	}
+	it.next = index
+	return nil
+}
+
+// Next advances the iterator to the next combination. It returns false when there are
+// no more combinations.
+func (it *Iterator) Next() bool {
+	if it.next >= it.total {
+		return false
+	}
+	it.index = it.next
+	it.next++
+	return true
+}
+
+// Index returns the number of the current combination, starting from 0
+func (it *Iterator) Index() uint64 {
+	return it.index
+}
+
+// Combination returns the current combination, having the elements indexes in increasing order
+func (it *Iterator) Combination() []int {
+	index, p := it.index, 0
+	for index >= it.counts[p] {
+		index -= it.counts[p]
+		p++
+	}
+
+	// The combination number index of the part is taken as a mixed radix number, having a digit
+	// for each group. The last group changes faster.
+	part := it.parts[p]
+	ranks := make([]uint64, len(part))
+	for g := len(part) - 1; g >= 0; g-- {
+		count := Count(it.groups[g][1]-it.groups[g][0], part[g])
+		ranks[g] = index % count
+		index /= count
+	}
+
+	c := []int{}
+	for g, k := range part {
+		for _, i := range unrank(it.groups[g][1]-it.groups[g][0], k, ranks[g]) {
+			c = append(c, it.order[it.groups[g][0]+i])
+		}
+	}
+	sort.Ints(c)
+	return c
+}
+
+// Count returns the number of combinations of k elements out of n
+func Count(n, k int) uint64 {
+	if k < 0 || k > n {
+		return 0
+	}
+	if k > n-k {
+		k = n - k
+	}
+	count := uint64(1)
+	for i := 1; i <= k; i++ {
+		count = count * uint64(n-k+i) / uint64(i)
+	}
+	return count
+}
+
+// unrank returns the combination number index, in lexicographic order
+func unrank(n, k int, index uint64) []int {
+	indexes := make([]int, k)
+	next := 0
+	for i := 0; i < k; i++ {
+		for c := next; c < n; c++ {
+			// number of combinations starting with c at position i
+			count := Count(n-c-1, k-i-1)
+			if index < count {
+				indexes[i] = c
+				next = c + 1
+				break
+			}
+			index -= count
+		}
+	}
+	return indexes
+}
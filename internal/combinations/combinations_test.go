@@ -0,0 +1,112 @@
+package combinations
+
+import (
+	"fmt"
+	"testing"
+
+	tu "github.com/Percona-Lab/minimum_permissions/internal/testutils"
+)
+
+func TestIterator(t *testing.T) {
+	want := [][]int{{0, 1, 2}, {0, 1, 3}, {0, 2, 3}, {1, 2, 3}}
+
+	got := [][]int{}
+	it := New(make([]int, 4), 3)
+	for i := uint64(0); it.Next(); i++ {
+		tu.Equals(t, i, it.Index())
+		got = append(got, it.Combination())
+	}
+	tu.Equals(t, want, got)
+	tu.Equals(t, uint64(4), it.Total())
+}
+
+func TestSeek(t *testing.T) {
+	all := [][]int{}
+	it := New(make([]int, 6), 3)
+	for it.Next() {
+		all = append(all, it.Combination())
+	}
+	tu.Equals(t, int(Count(6, 3)), len(all))
+
+	for start := range all {
+		it := New(make([]int, 6), 3)
+		err := it.Seek(uint64(start))
+		tu.IsNil(t, err)
+
+		got := [][]int{}
+		for it.Next() {
+			got = append(got, it.Combination())
+		}
+		tu.Equals(t, all[start:], got)
+	}
+
+	err := New(make([]int, 6), 3).Seek(20)
+	tu.NotOk(t, err)
+}
+
+func TestCount(t *testing.T) {
+	tu.Equals(t, uint64(3), Count(3, 2))
+	tu.Equals(t, uint64(1), Count(3, 0))
+	tu.Equals(t, uint64(0), Count(3, 4))
+	tu.Equals(t, uint64(472733756), Count(38, 10))
+}
+
+func TestEmpty(t *testing.T) {
+	it := New(make([]int, 2), 3)
+	tu.Assert(t, !it.Next(), "There are no combinations of 3 elements out of 2")
+}
+
+func TestIteratorWeights(t *testing.T) {
+	// The lowest total weight is generated first, even if it uses the last element
+	want := [][]int{{0, 1}, {0, 2}, {1, 2}, {0, 3}, {1, 3}, {2, 3}}
+	got := [][]int{}
+	it := New([]int{1, 1, 3, 10}, 2)
+	for i := uint64(0); it.Next(); i++ {
+		tu.Equals(t, i, it.Index())
+		got = append(got, it.Combination())
+	}
+	tu.Equals(t, want, got)
+	tu.Equals(t, uint64(6), it.Total())
+
+	got = [][]int{}
+	it = New([]int{10, 1, 3, 1}, 2)
+	for it.Next() {
+		got = append(got, it.Combination())
+	}
+	tu.Equals(t, [][]int{{1, 3}, {1, 2}, {2, 3}, {0, 1}, {0, 3}, {0, 2}}, got)
+}
+
+func TestSeekWeights(t *testing.T) {
+	weights := []int{1, 1, 1, 3, 3, 10, 10}
+	all := [][]int{}
+	it := New(weights, 3)
+	for it.Next() {
+		all = append(all, it.Combination())
+	}
+	tu.Equals(t, int(Count(7, 3)), len(all))
+
+	seen, last := map[string]bool{}, 0
+	for _, c := range all {
+		seen[fmt.Sprint(c)] = true
+		weight := 0
+		for _, i := range c {
+			weight += weights[i]
+		}
+		tu.Assert(t, weight >= last, "%v weight %d is lower than the previous one %d", c, weight, last)
+		last = weight
+	}
+	tu.Equals(t, len(all), len(seen))
+
+	for start := range all {
+		it := New(weights, 3)
+		tu.IsNil(t, it.Seek(uint64(start)))
+
+		got := [][]int{}
+		for it.Next() {
+			got = append(got, it.Combination())
+		}
+		tu.Equals(t, all[start:], got)
+	}
+
+	tu.NotOk(t, New(weights, 3).Seek(35))
+}
@@ -0,0 +1,316 @@
+package qparser
+
+import (
+	"strings"
+)
+
+// Definition is a stored procedure, function, view or trigger definition
+type Definition struct {
+	// Kind is PROCEDURE, FUNCTION, VIEW or TRIGGER
+	Kind        string
+	Database    string
+	Name        string
+	DefinerUser string
+	DefinerHost string
+	// Invoker is true for the routines and views having SQL SECURITY INVOKER
+	Invoker bool
+	// Table and Event are the table and the event (INSERT, UPDATE or DELETE) of a trigger
+	Table string
+	Event string
+	// Statements are the statements in the body. The routine parameters and local variables and
+	// the NEW and OLD columns of the triggers are replaced by ? placeholders, and the INTO list of
+	// the SELECT ... INTO statements is removed, so the statements can run outside the body.
+	Statements []string
+}
+
+// ParseDefinition parses a CREATE PROCEDURE, FUNCTION, VIEW or TRIGGER statement, as in the
+// SHOW CREATE output or in mysqldump files. Unqualified names are assigned to defaultDB. It
+// returns false if the statement doesn't create one of those objects.
+func ParseDefinition(stmt, defaultDB string) (*Definition, bool) {
+	runes := []rune(stmt)
+	tokens := tokenize(stmt)
+	if len(tokens) == 0 || !tokens[0].is("CREATE") {
+		return nil, false
+	}
+
+	d := &Definition{Database: defaultDB}
+	i := 1
+header:
+	for ; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.is("OR", "REPLACE", "AGGREGATE"):
+		case t.is("ALGORITHM"):
+			i += 2
+		case t.is("DEFINER"):
+			i = d.readDefiner(tokens, i+2) - 1
+		case t.is("SQL") && tokenIs(tokens, i+1, "SECURITY"):
+			d.Invoker = tokenIs(tokens, i+2, "INVOKER")
+			i += 2
+		case t.is("PROCEDURE", "FUNCTION", "VIEW", "TRIGGER"):
+			d.Kind = strings.ToUpper(t.value)
+			break header
+		default:
+			return nil, false
+		}
+	}
+	if d.Kind == "" {
+		return nil, false
+	}
+	i++
+	if tokenIs(tokens, i, "IF") {
+		i += 3
+	}
+	d.Database, d.Name, i = readName(tokens, i, d.Database)
+	if d.Name == "" {
+		return nil, false
+	}
+
+	switch d.Kind {
+	case "VIEW":
+		d.Statements = viewStatements(runes, tokens[i:])
+	case "TRIGGER":
+		if i+2 >= len(tokens) {
+			return nil, false
+		}
+		d.Event = strings.ToUpper(tokens[i+1].value)
+		d.Database, d.Table, i = readName(tokens, i+3, d.Database)
+		// FOR EACH ROW [FOLLOWS | PRECEDES other_trigger]
+		i += 3
+		if tokenIs(tokens, i, "FOLLOWS", "PRECEDES") {
+			_, _, i = readName(tokens, i+1, d.Database)
+		}
+		if i < len(tokens) {
+			d.Statements = bodyStatements(runes, tokens[i:], nil, true)
+		}
+	default:
+		params := []string{}
+		if tokenIs(tokens, i, "(") {
+			params, i = readParameters(tokens, i)
+		}
+		for ; i < len(tokens) && !isBodyStart(tokens, i); i++ {
+			if tokens[i].is("SQL") && tokenIs(tokens, i+1, "SECURITY") {
+				d.Invoker = tokenIs(tokens, i+2, "INVOKER")
+				i += 2
+			}
+		}
+		if i < len(tokens) {
+			d.Statements = bodyStatements(runes, tokens[i:], params, false)
+		}
+	}
+
+	return d, true
+}
+
+func tokenIs(tokens []token, i int, words ...string) bool {
+	return i >= 0 && i < len(tokens) && tokens[i].is(words...)
+}
+
+// readDefiner reads the definer account starting at i and returns the position after it:
+// `user`@`host`, 'user'@'host', user@host or CURRENT_USER[()]
+func (d *Definition) readDefiner(tokens []token, i int) int {
+	if i >= len(tokens) {
+		return i
+	}
+	d.DefinerUser = unquote(tokens[i])
+	if tokens[i].is("CURRENT_USER") {
+		d.DefinerUser = "CURRENT_USER"
+		if tokenIs(tokens, i+1, "(") {
+			return i + 3
+		}
+		return i + 1
+	}
+	if i+1 >= len(tokens) || tokens[i+1].kind != tkVariable {
+		return i + 1
+	}
+	if tokens[i+1].value != "@" {
+		d.DefinerHost = tokens[i+1].value[1:]
+		return i + 2
+	}
+	if i+2 < len(tokens) {
+		d.DefinerHost = unquote(tokens[i+2])
+	}
+	return i + 3
+}
+
+// readName reads a name that can be qualified with the database and returns the position
+// after it
+func readName(tokens []token, i int, defaultDB string) (string, string, int) {
+	if i >= len(tokens) || (tokens[i].kind != tkWord && tokens[i].kind != tkQuoted) {
+		return defaultDB, "", i
+	}
+	if tokenIs(tokens, i+1, ".") && i+2 < len(tokens) {
+		return unquote(tokens[i]), unquote(tokens[i+2]), i + 3
+	}
+	return defaultDB, unquote(tokens[i]), i + 1
+}
+
+// readParameters returns the names of the routine parameters in the list starting at i, and the
+// position after the list: ([IN | OUT | INOUT] name type, ...)
+func readParameters(tokens []token, i int) ([]string, int) {
+	params := []string{}
+	depth := 0
+	expectName := false
+	for ; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.is("("):
+			depth++
+			expectName = depth == 1
+			continue
+		case t.is(")"):
+			depth--
+			if depth == 0 {
+				return params, i + 1
+			}
+		case t.is(",") && depth == 1:
+			expectName = true
+			continue
+		case expectName && t.is("IN", "OUT", "INOUT"):
+			continue
+		case expectName:
+			params = append(params, unquote(t))
+		}
+		expectName = false
+	}
+	return params, i
+}
+
+// isBodyStart returns true if the routine body starts at the token i, after the parameters,
+// the return type and the routine characteristics
+func isBodyStart(tokens []token, i int) bool {
+	t := tokens[i]
+	switch {
+	case t.is("BEGIN", "SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE", "CALL", "RETURN", "IF", "CASE",
+		"WHILE", "REPEAT", "LOOP", "WITH", "DO"):
+		return true
+	case t.is("SET"):
+		// CHARACTER SET in the return type
+		return i == 0 || !tokens[i-1].is("CHARACTER")
+	case t.isIdent() && tokenIs(tokens, i+1, ":"):
+		// Label
+		return true
+	}
+	return false
+}
+
+// viewStatements returns the SELECT statement of a view: the statement after AS, without the
+// WITH CHECK OPTION clause
+func viewStatements(runes []rune, tokens []token) []string {
+	depth := 0
+	for i, t := range tokens {
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case t.is("AS") && depth == 0 && i+1 < len(tokens):
+			body := tokens[i+1:]
+			if n := len(body); n > 3 && body[n-2].is("CHECK") && body[n-1].is("OPTION") {
+				body = body[:n-3]
+				if body[len(body)-1].is("WITH") {
+					body = body[:len(body)-1]
+				}
+			}
+			return []string{statementText(runes, body, nil, false)}
+		}
+	}
+	return nil
+}
+
+// bodyStatements returns the statements accessing tables or calling routines in the body of a
+// routine or trigger: SELECT, INSERT, UPDATE, DELETE, REPLACE and CALL statements, including the
+// ones in cursors and the subqueries in conditions and assignments.
+func bodyStatements(runes []rune, tokens []token, vars []string, trigger bool) []string {
+	// Local variables: DECLARE a, b INT
+	for i, t := range tokens {
+		if !t.is("DECLARE") || tokenIs(tokens, i+1, "CONTINUE", "EXIT", "UNDO") {
+			continue
+		}
+		for j := i + 1; j < len(tokens); j += 2 {
+			vars = append(vars, unquote(tokens[j]))
+			if !tokenIs(tokens, j+1, ",") {
+				break
+			}
+		}
+	}
+
+	statements := []string{}
+	start := 0
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) && !tokens[i].is(";") {
+			continue
+		}
+		statements = append(statements, extractStatements(runes, tokens[start:i], vars, trigger)...)
+		start = i + 1
+	}
+	return statements
+}
+
+// extractStatements returns the statements in a piece of a body between semicolons, like
+// IF (SELECT COUNT(*) FROM t) > 0 THEN UPDATE t SET a = 1
+func extractStatements(runes []rune, tokens []token, vars []string, trigger bool) []string {
+	statements := []string{}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if !t.is("SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE", "CALL", "WITH") {
+			continue
+		}
+		// The INSERT() and REPLACE() string functions
+		if t.is("INSERT", "REPLACE") && tokenIs(tokens, i+1, "(") {
+			continue
+		}
+		end := len(tokens)
+		if i > 0 && tokens[i-1].is("(") {
+			// A subquery ends at the matching parenthesis
+			depth := 1
+			for end = i; end < len(tokens); end++ {
+				if tokens[end].is("(") {
+					depth++
+				}
+				if tokens[end].is(")") {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+		}
+		statements = append(statements, statementText(runes, tokens[i:end], vars, trigger))
+		i = end
+	}
+	return statements
+}
+
+// statementText returns the text of the statement, replacing the variables and the trigger
+// NEW and OLD columns by placeholders and removing the SELECT ... INTO list
+func statementText(runes []rune, tokens []token, vars []string, trigger bool) string {
+	b := &strings.Builder{}
+	last := tokens[0].pos
+	replace := func(from, to int, text string) {
+		b.WriteString(string(runes[last:tokens[from].pos]))
+		b.WriteString(text)
+		last = tokens[to].end
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case trigger && t.is("NEW", "OLD") && tokenIs(tokens, i+1, ".") && i+2 < len(tokens):
+			replace(i, i+2, "?")
+			i += 2
+		case t.is("INTO") && tokens[0].is("SELECT") && i+1 < len(tokens) && !tokens[i+1].is("OUTFILE", "DUMPFILE"):
+			j := i + 1
+			for j+2 < len(tokens) && tokens[j+1].is(",") {
+				j += 2
+			}
+			replace(i, j, "")
+			i = j
+		case (t.kind == tkWord || t.kind == tkQuoted) && containsFold(vars, unquote(t)) &&
+			!tokenIs(tokens, i-1, ".") && !tokenIs(tokens, i+1, "."):
+			replace(i, i, "?")
+		}
+	}
+	b.WriteString(string(runes[last:tokens[len(tokens)-1].end]))
+
+	return strings.TrimSpace(b.String())
+}
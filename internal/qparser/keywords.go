@@ -0,0 +1,40 @@
+package qparser
+
+// reserved holds the words that cannot be unquoted schema, table or column names.
+// It includes MySQL reserved words plus some non reserved keywords commonly found in the
+// same positions as identifiers (interval units, lock types, etc).
+var reserved = map[string]bool{
+	"ACCESSIBLE": true, "ADD": true, "AFTER": true, "ALGORITHM": true, "ALL": true, "ALTER": true,
+	"ANALYZE": true, "AND": true, "AS": true, "ASC": true, "BEFORE": true, "BETWEEN": true,
+	"BINARY": true, "BOTH": true, "BY": true, "CALL": true, "CASCADE": true, "CASE": true,
+	"CHANGE": true, "CHARACTER": true, "CHECK": true, "CHECKSUM": true, "COLLATE": true,
+	"COLUMN": true, "COLUMNS": true, "CONSTRAINT": true, "CREATE": true, "CROSS": true,
+	"CURRENT_DATE": true, "CURRENT_TIME": true, "CURRENT_TIMESTAMP": true, "CURRENT_USER": true,
+	"DATABASE": true, "DATABASES": true, "DAY": true, "DAY_HOUR": true, "DAY_MINUTE": true,
+	"DAY_SECOND": true, "DEFAULT": true, "DEFINER": true, "DELAYED": true, "DELETE": true,
+	"DESC": true, "DESCRIBE": true, "DISTINCT": true, "DISTINCTROW": true, "DIV": true,
+	"DROP": true, "DUAL": true, "DUMPFILE": true, "DUPLICATE": true, "EACH": true, "ELSE": true,
+	"ELSEIF": true, "END": true, "ESCAPE": true, "EXISTS": true, "EXPLAIN": true, "FALSE": true,
+	"FIELDS": true, "FLUSH": true, "FOR": true, "FORCE": true, "FOREIGN": true, "FROM": true,
+	"FULL": true, "FULLTEXT": true, "GRANT": true, "GROUP": true, "HAVING": true,
+	"HIGH_PRIORITY": true, "HOUR": true, "IF": true, "IGNORE": true, "IN": true, "INDEX": true,
+	"INDEXES": true, "INFILE": true, "INNER": true, "INSERT": true, "INTERVAL": true, "INTO": true,
+	"INVOKER": true, "IS": true, "JOIN": true, "KEY": true, "KEYS": true, "KILL": true,
+	"LEADING": true, "LEFT": true, "LIKE": true, "LIMIT": true, "LINES": true, "LOAD": true,
+	"LOCAL": true, "LOCK": true, "LOW_PRIORITY": true, "MICROSECOND": true, "MINUTE": true,
+	"MOD": true, "MODE": true, "MODIFY": true, "MONTH": true, "NATURAL": true, "NOT": true,
+	"NULL": true, "OFFSET": true, "ON": true, "OPTIMIZE": true, "OR": true, "ORDER": true,
+	"OUTER": true, "OUTFILE": true, "OVER": true, "PARTITION": true, "PRIMARY": true,
+	"PROCEDURE": true, "QUARTER": true, "QUICK": true, "READ": true, "REFERENCES": true,
+	"REGEXP": true, "RENAME": true, "REPAIR": true, "REPLACE": true, "RESTRICT": true,
+	"REVOKE": true, "RIGHT": true, "RLIKE": true, "ROLLUP": true, "ROW": true, "SCHEMA": true,
+	"SECOND": true, "SECURITY": true, "SELECT": true, "SEPARATOR": true, "SET": true,
+	"SHARE": true, "SHOW": true, "SQL": true, "SQL_BIG_RESULT": true, "SQL_BUFFER_RESULT": true,
+	"SQL_CACHE": true, "SQL_CALC_FOUND_ROWS": true, "SQL_NO_CACHE": true,
+	"SQL_SMALL_RESULT": true, "STATUS": true, "STRAIGHT_JOIN": true, "TABLE": true,
+	"TABLES": true, "TEMPORARY": true, "TERMINATED": true, "THEN": true, "TO": true,
+	"TRAILING": true, "TRIGGER": true, "TRUE": true, "TRUNCATE": true, "UNION": true,
+	"UNIQUE": true, "UNLOCK": true, "UPDATE": true, "USE": true, "USING": true, "VALUE": true,
+	"VALUES": true, "VIEW": true, "WEEK": true, "WHEN": true, "WHERE": true, "WINDOW": true,
+	"WITH": true, "WRITE": true, "XOR": true, "YEAR": true,
+}
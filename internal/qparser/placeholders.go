@@ -0,0 +1,145 @@
+package qparser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HasPlaceholders returns true if the query has ? placeholders outside strings and comments
+func HasPlaceholders(query string) bool {
+	for _, t := range tokenize(query) {
+		if t.kind == tkPunct && t.value == "?" {
+			return true
+		}
+	}
+	return false
+}
+
+// PlaceholderValues returns a value for each ? placeholder in the query, to execute it as a
+// prepared statement. The values are guessed from the column compared with or inserted into the
+// placeholder: numbers for ids and counters, dates for date and time columns and strings
+// otherwise. LIMIT and OFFSET take numbers and LIKE takes '%'.
+func PlaceholderValues(query string) []interface{} {
+	tokens := tokenize(query)
+	columns := insertColumns(tokens)
+	values := []interface{}{}
+	inValues := false
+	depth := 0
+	// position is the position of the value in the VALUES tuple
+	position := 0
+
+	for i, t := range tokens {
+		switch {
+		case t.is("VALUES", "VALUE") && depth == 0:
+			inValues = true
+		case t.is("("):
+			depth++
+			if inValues && depth == 1 {
+				position = 0
+			}
+		case t.is(")"):
+			depth--
+		case t.is(",") && inValues && depth == 1:
+			position++
+		case t.is("?"):
+			if inValues && depth == 1 && position < len(columns) {
+				values = append(values, columnValue(columns[position]))
+				continue
+			}
+			values = append(values, placeholderValue(tokens, i))
+		}
+	}
+
+	return values
+}
+
+// Interpolate replaces the ? placeholders in the query by the values returned by
+// PlaceholderValues, as SQL literals.
+func Interpolate(query string) string {
+	tokens := tokenize(query)
+	values := PlaceholderValues(query)
+	runes := []rune(query)
+	b := &strings.Builder{}
+	last := 0
+	n := 0
+	for _, t := range tokens {
+		if t.kind != tkPunct || t.value != "?" {
+			continue
+		}
+		b.WriteString(string(runes[last:t.pos]))
+		switch v := values[n].(type) {
+		case string:
+			b.WriteString("'" + v + "'")
+		default:
+			b.WriteString(fmt.Sprint(v))
+		}
+		last = t.pos + 1
+		n++
+	}
+	b.WriteString(string(runes[last:]))
+	return b.String()
+}
+
+// insertColumns returns the column list of INSERT and REPLACE statements:
+// INSERT INTO t (col1, col2) VALUES (?, ?)
+func insertColumns(tokens []token) []string {
+	if !strings.EqualFold(firstWord(tokens), "INSERT") && !strings.EqualFold(firstWord(tokens), "REPLACE") {
+		return nil
+	}
+	columns := []string{}
+	for i, t := range tokens {
+		if t.is("VALUES", "VALUE", "SELECT", "SET") {
+			break
+		}
+		if !t.is("(") {
+			continue
+		}
+		for _, c := range tokens[i+1:] {
+			if c.is(")") {
+				break
+			}
+			if c.isIdent() {
+				columns = append(columns, unquote(c))
+			}
+		}
+		break
+	}
+	return columns
+}
+
+// placeholderValue guesses the value for the placeholder at position i from the previous tokens:
+// WHERE id = ?, id IN (?, ?), created BETWEEN ? AND ?, LIMIT ?, ?
+func placeholderValue(tokens []token, i int) interface{} {
+	for j := i - 1; j >= 0; j-- {
+		t := tokens[j]
+		switch {
+		case t.is("LIMIT", "OFFSET"):
+			return int64(1)
+		case t.is("LIKE"):
+			return "%"
+		case t.is("?", ",", "(", "=", "<", ">", "!", "IN", "NOT", "BETWEEN", "AND"),
+			t.kind == tkNumber, t.kind == tkString:
+		case t.isIdent():
+			return columnValue(t.value)
+		default:
+			return columnValue("")
+		}
+	}
+	return columnValue("")
+}
+
+// columnValue returns a plausible value for the column, based on its name
+func columnValue(column string) interface{} {
+	name := strings.ToLower(column)
+	switch {
+	case strings.Contains(name, "date"):
+		return "2000-01-01"
+	case strings.Contains(name, "time"), strings.HasSuffix(name, "_at"):
+		return "2000-01-01 00:00:00"
+	case name == "id", strings.HasSuffix(name, "_id"), strings.HasSuffix(name, "count"),
+		strings.HasPrefix(name, "num"), strings.Contains(name, "amount"), strings.Contains(name, "price"),
+		strings.Contains(name, "total"), strings.Contains(name, "qty"), strings.Contains(name, "quantity"):
+		return int64(1)
+	}
+	return "1"
+}
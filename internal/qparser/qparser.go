@@ -0,0 +1,609 @@
+package qparser
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Table is a table (or view) referenced by a query
+type Table struct {
+	Database string
+	Name     string
+	Alias    string
+	Columns  []string
+}
+
+// Objects holds the database objects referenced by a query
+type Objects struct {
+	Databases []string
+	Tables    []*Table
+	// ColumnsKnown is true only if all the columns used by the query could be assigned to
+	// their tables. It is false for SELECT *, ambiguous unqualified columns in joins and
+	// for non DML statements.
+	ColumnsKnown bool
+}
+
+type tokenKind int
+
+const (
+	tkWord tokenKind = iota
+	tkQuoted
+	tkString
+	tkNumber
+	tkVariable
+	tkPunct
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	// pos and end are the position of the token in the query runes
+	pos int
+	end int
+}
+
+// isIdent returns true if the token can be used as a schema, table or column name
+func (t token) isIdent() bool {
+	if t.kind == tkQuoted {
+		return true
+	}
+	return t.kind == tkWord && !reserved[strings.ToUpper(t.value)]
+}
+
+func (t token) is(words ...string) bool {
+	if t.kind != tkWord && t.kind != tkPunct {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.value, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse extracts the schemas, tables and columns referenced by a query.
+// Unqualified table names are assigned to defaultDB.
+// The parser is not a full SQL parser; it only recognizes the most common statements.
+func Parse(query, defaultDB string) *Objects {
+	p := &parser{tokens: tokenize(query), defaultDB: defaultDB}
+	p.parse()
+
+	return p.objects()
+}
+
+type parser struct {
+	tokens    []token
+	pos       int
+	defaultDB string
+	databases []string
+	tables    []*Table
+	columns   []columnRef
+	wildcard  bool
+	// used holds the positions of the tokens already consumed as schema, table or alias names
+	used map[int]bool
+}
+
+type columnRef struct {
+	qualifier string
+	name      string
+}
+
+func (p *parser) peek(offset int) token {
+	if p.pos+offset < len(p.tokens) && p.pos+offset >= 0 {
+		return p.tokens[p.pos+offset]
+	}
+	return token{kind: tkPunct, value: ""}
+}
+
+func (p *parser) parse() {
+	p.used = make(map[int]bool)
+	if len(p.tokens) == 0 {
+		return
+	}
+	first := strings.ToUpper(p.tokens[0].value)
+
+	switch first {
+	case "USE":
+		p.pos = 1
+		p.readDatabase()
+		return
+	case "SHOW":
+		p.parseShow()
+		return
+	case "DESC", "DESCRIBE", "EXPLAIN":
+		if p.peek(1).isIdent() {
+			p.pos = 1
+			p.readTable()
+			return
+		}
+	case "CALL":
+		p.pos = 1
+		if p.peek(1).is(".") {
+			p.addDatabase(unquote(p.peek(0)))
+			return
+		}
+		p.addDatabase(p.defaultDB)
+		return
+	}
+
+	// parens is a stack to know if we are inside a subquery or inside a function call
+	// like EXTRACT(YEAR FROM col), where FROM doesn't introduce a table reference.
+	parens := []bool{}
+	inSubquery := func() bool {
+		return len(parens) == 0 || parens[len(parens)-1]
+	}
+
+	for p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		p.pos++
+
+		switch {
+		case tok.is("("):
+			parens = append(parens, p.peek(0).is("SELECT"))
+		case tok.is(")"):
+			if len(parens) > 0 {
+				parens = parens[:len(parens)-1]
+			}
+		case tok.is("FROM") && inSubquery():
+			p.readTableList()
+		case tok.is("JOIN"):
+			p.readTable()
+		case tok.is("INTO") && (first == "INSERT" || first == "REPLACE" || first == "LOAD"):
+			if p.peek(0).is("TABLE") {
+				p.pos++
+			}
+			p.readTable()
+		case tok.is("UPDATE") && p.pos == 1:
+			for p.peek(0).is("LOW_PRIORITY", "IGNORE") {
+				p.pos++
+			}
+			p.readTableList()
+		case tok.is("TABLE", "TABLES") && p.pos > 1 && !p.peek(-2).is("INTO"):
+			p.skipIfExists()
+			p.readTableList()
+		case tok.is("TRUNCATE") && p.pos == 1:
+			p.readTable()
+		case tok.is("REFERENCES") && isDDL(first):
+			p.readTable()
+		case tok.is("DATABASE", "SCHEMA") && p.pos > 1 && p.peek(-2).is("CREATE", "DROP", "ALTER"):
+			p.skipIfExists()
+			p.readDatabase()
+		case tok.is("VIEW") && isDDL(first):
+			p.skipIfExists()
+			p.readTable()
+		case tok.is("ON") && first == "CREATE" && p.declaresIndexOrTrigger():
+			p.readTable()
+		case tok.is("*") && p.peek(-2).is("SELECT", "DISTINCT", ",", "."):
+			p.wildcard = true
+		}
+	}
+
+	if isDML(first) {
+		p.collectColumns()
+	}
+}
+
+// parseShow handles SHOW statements referencing a schema or a table.
+// SHOW TABLES FROM db, SHOW COLUMNS FROM t [FROM db], SHOW CREATE TABLE t, etc.
+func (p *parser) parseShow() {
+	for p.pos = 1; p.pos < len(p.tokens); p.pos++ {
+		tok := p.tokens[p.pos]
+		switch {
+		case tok.is("TABLE", "VIEW", "TRIGGER", "EVENT", "PROCEDURE", "FUNCTION") && p.peek(-1).is("CREATE"):
+			p.pos++
+			if tok.is("TABLE", "VIEW") {
+				p.readTable()
+				return
+			}
+			if p.peek(1).is(".") {
+				p.addDatabase(unquote(p.peek(0)))
+			}
+			return
+		case tok.is("DATABASE", "SCHEMA") && p.peek(-1).is("CREATE"):
+			p.pos++
+			p.skipIfExists()
+			p.readDatabase()
+			return
+		case tok.is("FROM", "IN"):
+			p.pos++
+			if p.peek(-2).is("COLUMNS", "FIELDS", "INDEX", "INDEXES", "KEYS") {
+				t := p.readTable()
+				if t != nil && p.peek(0).is("FROM", "IN") {
+					p.pos++
+					if p.peek(0).isIdent() {
+						t.Database = unquote(p.peek(0))
+						p.used[p.pos] = true
+					}
+				}
+				return
+			}
+			p.readDatabase()
+			return
+		}
+	}
+}
+
+// declaresIndexOrTrigger returns true for CREATE INDEX ... ON t and CREATE TRIGGER ... ON t
+func (p *parser) declaresIndexOrTrigger() bool {
+	for i := 1; i < p.pos; i++ {
+		if p.tokens[i].is("INDEX", "TRIGGER") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) skipIfExists() {
+	if p.peek(0).is("IF") {
+		p.pos++
+		if p.peek(0).is("NOT") {
+			p.pos++
+		}
+		if p.peek(0).is("EXISTS") {
+			p.pos++
+		}
+	}
+}
+
+func (p *parser) readDatabase() {
+	if p.peek(0).isIdent() {
+		p.used[p.pos] = true
+		p.addDatabase(unquote(p.peek(0)))
+	}
+}
+
+func (p *parser) readTableList() {
+	for p.readTable() != nil {
+		p.skipTableModifiers()
+		if !p.peek(0).is(",") {
+			return
+		}
+		p.pos++
+	}
+}
+
+// skipTableModifiers skips READ/WRITE locks in LOCK TABLES, partition lists and index hints.
+func (p *parser) skipTableModifiers() {
+	for {
+		switch {
+		case p.peek(0).is("READ", "WRITE", "LOCAL", "LOW_PRIORITY"):
+			p.pos++
+		case p.peek(0).is("PARTITION", "USE", "FORCE", "IGNORE"):
+			for p.pos < len(p.tokens) && !p.peek(0).is("(") {
+				p.pos++
+			}
+			for depth := 0; p.pos < len(p.tokens); p.pos++ {
+				if p.peek(0).is("(") {
+					depth++
+				}
+				if p.peek(0).is(")") {
+					depth--
+					if depth == 0 {
+						p.pos++
+						break
+					}
+				}
+			}
+		default:
+			return
+		}
+	}
+}
+
+// readTable reads a [db.]table [[AS] alias] reference starting at the current position.
+// On return, the current position points to the token after the reference.
+func (p *parser) readTable() *Table {
+	if !p.peek(0).isIdent() || strings.EqualFold(p.peek(0).value, "DUAL") {
+		return nil
+	}
+	t := &Table{Name: unquote(p.peek(0))}
+	p.used[p.pos] = true
+	p.pos++
+	// After the dot, reserved words are also table names: information_schema.tables
+	if next := p.peek(1); p.peek(0).is(".") && (next.kind == tkWord || next.kind == tkQuoted) {
+		t.Database = t.Name
+		t.Name = unquote(p.peek(1))
+		p.used[p.pos+1] = true
+		p.pos += 2
+	}
+	if p.peek(0).is("AS") {
+		p.pos++
+	}
+	if p.peek(0).isIdent() {
+		t.Alias = unquote(p.peek(0))
+		p.used[p.pos] = true
+		p.pos++
+	}
+	p.tables = append(p.tables, t)
+
+	return t
+}
+
+func (p *parser) addDatabase(name string) {
+	if name == "" {
+		return
+	}
+	for _, db := range p.databases {
+		if db == name {
+			return
+		}
+	}
+	p.databases = append(p.databases, name)
+}
+
+// collectColumns walks the tokens looking for column names. Every identifier that was not
+// used as a schema, table or alias name and that is not a function call, is a column.
+func (p *parser) collectColumns() {
+	for i, tok := range p.tokens {
+		if p.used[i] || !tok.isIdent() {
+			continue
+		}
+		if i+1 < len(p.tokens) && p.tokens[i+1].is("(") && tok.kind == tkWord {
+			continue
+		}
+		if i > 0 && p.tokens[i-1].is("AS") {
+			continue
+		}
+		// skip the qualifiers, we will read them when reaching the column name
+		if i+1 < len(p.tokens) && p.tokens[i+1].is(".") {
+			continue
+		}
+		ref := columnRef{name: unquote(tok)}
+		if i > 1 && p.tokens[i-1].is(".") {
+			ref.qualifier = unquote(p.tokens[i-2])
+		}
+		p.columns = append(p.columns, ref)
+	}
+}
+
+func (p *parser) objects() *Objects {
+	o := &Objects{}
+
+	for _, t := range p.tables {
+		if t.Database == "" {
+			t.Database = p.defaultDB
+		}
+		p.addDatabase(t.Database)
+	}
+	o.Databases = p.databases
+	o.Tables = p.tables
+
+	if !isDML(firstWord(p.tokens)) || p.wildcard || len(p.tables) == 0 {
+		return o
+	}
+
+	o.ColumnsKnown = true
+	for _, col := range p.columns {
+		t := p.findTable(col.qualifier)
+		if t == nil {
+			o.ColumnsKnown = false
+			break
+		}
+		t.addColumn(col.name)
+	}
+	if !o.ColumnsKnown {
+		for _, t := range p.tables {
+			t.Columns = nil
+		}
+	}
+
+	return o
+}
+
+func (p *parser) findTable(qualifier string) *Table {
+	if qualifier == "" {
+		if len(p.tables) == 1 {
+			return p.tables[0]
+		}
+		return nil
+	}
+	for _, t := range p.tables {
+		if t.Alias == qualifier || (t.Alias == "" && t.Name == qualifier) {
+			return t
+		}
+	}
+	return nil
+}
+
+func (t *Table) addColumn(name string) {
+	for _, c := range t.Columns {
+		if strings.EqualFold(c, name) {
+			return
+		}
+	}
+	t.Columns = append(t.Columns, name)
+}
+
+func firstWord(tokens []token) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+	return strings.ToUpper(tokens[0].value)
+}
+
+func isDML(first string) bool {
+	switch first {
+	case "SELECT", "INSERT", "REPLACE", "UPDATE", "DELETE":
+		return true
+	}
+	return false
+}
+
+func isDDL(first string) bool {
+	switch first {
+	case "CREATE", "ALTER", "DROP":
+		return true
+	}
+	return false
+}
+
+func unquote(t token) string {
+	if t.kind == tkQuoted {
+		return strings.Replace(t.value, "``", "`", -1)
+	}
+	return t.value
+}
+
+// tokenize splits a query into tokens, removing comments. The content of MySQL versioned
+// comments like /*!40101 SET NAMES utf8 */ is kept since MySQL executes it.
+func tokenize(query string) []token {
+	tokens := []token{}
+	runes := []rune(query)
+	inVersioned := false
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		tokenStart, count := i, len(tokens)
+		switch {
+		case unicode.IsSpace(r):
+		case r == '-' && i+2 < len(runes) && runes[i+1] == '-' && unicode.IsSpace(runes[i+2]), r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+2 < len(runes) && runes[i+1] == '*' && runes[i+2] == '!':
+			i += 2
+			for i+1 < len(runes) && unicode.IsDigit(runes[i+1]) {
+				i++
+			}
+			inVersioned = true
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			i++
+		case r == '*' && inVersioned && i+1 < len(runes) && runes[i+1] == '/':
+			inVersioned = false
+			i++
+		case r == '`' || r == '\'' || r == '"':
+			start := i + 1
+			for i++; i < len(runes); i++ {
+				if runes[i] == '\\' && r != '`' {
+					i++
+					continue
+				}
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			end := i
+			if end > len(runes) {
+				end = len(runes)
+			}
+			kind := tkString
+			if r == '`' {
+				kind = tkQuoted
+			}
+			tokens = append(tokens, token{kind: kind, value: string(runes[start:end])})
+		case r == '@':
+			start := i
+			for i+1 < len(runes) && (isWordRune(runes[i+1]) || runes[i+1] == '@' || runes[i+1] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tkVariable, value: string(runes[start : i+1])})
+		case unicode.IsDigit(r) && (len(tokens) == 0 || !tokens[len(tokens)-1].is(".")):
+			start := i
+			for i+1 < len(runes) && (isWordRune(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tkNumber, value: string(runes[start : i+1])})
+		case isWordRune(r):
+			start := i
+			for i+1 < len(runes) && isWordRune(runes[i+1]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tkWord, value: string(runes[start : i+1])})
+		default:
+			tokens = append(tokens, token{kind: tkPunct, value: string(r)})
+		}
+		if len(tokens) > count {
+			tokens[count].pos = tokenStart
+			tokens[count].end = i + 1
+			if tokens[count].end > len(runes) {
+				tokens[count].end = len(runes)
+			}
+		}
+	}
+
+	return tokens
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+var useRe = regexp.MustCompile("(?i)^\\s*USE\\s+(`(?:[^`]|``)+`|[^\\s;`]+)\\s*;?\\s*$")
+
+// UseDatabase returns the database if the query is a USE statement
+func UseDatabase(query string) (string, bool) {
+	m := useRe.FindStringSubmatch(query)
+	if m == nil {
+		return "", false
+	}
+	db := m[1]
+	if strings.HasPrefix(db, "`") {
+		db = strings.Replace(db[1:len(db)-1], "``", "`", -1)
+	}
+	return db, true
+}
+
+// ParseSession returns the objects referenced by a sequence of statements run in the same
+// session. USE statements change the default database for the following statements and the
+// columns are known only if they are known for all the statements referencing tables.
+func ParseSession(statements []string, defaultDB string) *Objects {
+	session := &Objects{ColumnsKnown: true}
+	tables := map[[2]string]*Table{}
+
+	for _, stmt := range statements {
+		if db, ok := UseDatabase(stmt); ok {
+			defaultDB = db
+			continue
+		}
+		o := Parse(stmt, defaultDB)
+		if len(o.Tables) == 0 && len(o.Databases) == 0 {
+			continue
+		}
+		session.ColumnsKnown = session.ColumnsKnown && o.ColumnsKnown
+	databases:
+		for _, db := range o.Databases {
+			for _, sdb := range session.Databases {
+				if sdb == db {
+					continue databases
+				}
+			}
+			session.Databases = append(session.Databases, db)
+		}
+		for _, t := range o.Tables {
+			key := [2]string{t.Database, strings.ToLower(t.Name)}
+			st, ok := tables[key]
+			if !ok {
+				st = &Table{Database: t.Database, Name: t.Name}
+				tables[key] = st
+				session.Tables = append(session.Tables, st)
+			}
+			for _, col := range t.Columns {
+				if !containsFold(st.Columns, col) {
+					st.Columns = append(st.Columns, col)
+				}
+			}
+		}
+	}
+	session.ColumnsKnown = session.ColumnsKnown && len(session.Tables) > 0
+
+	return session
+}
+
+func containsFold(list []string, item string) bool {
+	for _, i := range list {
+		if strings.EqualFold(i, item) {
+			return true
+		}
+	}
+	return false
+}
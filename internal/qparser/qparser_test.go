@@ -0,0 +1,243 @@
+package qparser
+
+import (
+	"testing"
+
+	tu "github.com/Percona-Lab/minimum_permissions/internal/testutils"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		Query string
+		Want  *Objects
+	}{
+		{
+			Query: "SELECT `i`, COUNT(*) FROM `d1`.`t` WHERE 1=1 GROUP BY i ORDER BY i LOCK IN SHARE MODE",
+			Want: &Objects{
+				Databases:    []string{"d1"},
+				Tables:       []*Table{{Database: "d1", Name: "t", Columns: []string{"i"}}},
+				ColumnsKnown: true,
+			},
+		},
+		{
+			Query: "SELECT f.title, a.first_name FROM sakila.film f JOIN sakila.actor AS a ON f.id = a.film_id",
+			Want: &Objects{
+				Databases: []string{"sakila"},
+				Tables: []*Table{
+					{Database: "sakila", Name: "film", Alias: "f", Columns: []string{"title", "id"}},
+					{Database: "sakila", Name: "actor", Alias: "a", Columns: []string{"first_name", "film_id"}},
+				},
+				ColumnsKnown: true,
+			},
+		},
+		{
+			Query: "SELECT * FROM film WHERE film_id = 1",
+			Want: &Objects{
+				Databases: []string{"test"},
+				Tables:    []*Table{{Database: "test", Name: "film"}},
+			},
+		},
+		{
+			Query: "insert into d1.t (a, b) values (2, 'c')",
+			Want: &Objects{
+				Databases:    []string{"d1"},
+				Tables:       []*Table{{Database: "d1", Name: "t", Columns: []string{"a", "b"}}},
+				ColumnsKnown: true,
+			},
+		},
+		{
+			Query: "UPDATE d1.t SET a = NOW() WHERE EXTRACT(YEAR FROM b) = 2018",
+			Want: &Objects{
+				Databases:    []string{"d1"},
+				Tables:       []*Table{{Database: "d1", Name: "t", Columns: []string{"a", "b"}}},
+				ColumnsKnown: true,
+			},
+		},
+		{
+			Query: "SELECT table_name FROM information_schema.tables WHERE table_schema = 'test'",
+			Want: &Objects{
+				Databases: []string{"information_schema"},
+				Tables: []*Table{{Database: "information_schema", Name: "tables",
+					Columns: []string{"table_name", "table_schema"}}},
+				ColumnsKnown: true,
+			},
+		},
+		{
+			Query: "LOCK TABLES `language` WRITE, d2.city READ",
+			Want: &Objects{
+				Databases: []string{"test", "d2"},
+				Tables:    []*Table{{Database: "test", Name: "language"}, {Database: "d2", Name: "city"}},
+			},
+		},
+		{
+			Query: "DROP DATABASE IF EXISTS `sakila`",
+			Want:  &Objects{Databases: []string{"sakila"}},
+		},
+		{
+			Query: "SHOW TABLES FROM mysql",
+			Want:  &Objects{Databases: []string{"mysql"}},
+		},
+		{
+			Query: "/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */",
+			Want:  &Objects{},
+		},
+		{
+			Query: "SHOW /*!40100 ENGINE*/ INNODB STATUS",
+			Want:  &Objects{},
+		},
+	}
+
+	for i, test := range tests {
+		got := Parse(test.Query, "test")
+		tu.Assert(t, len(got.Databases) == len(test.Want.Databases), "#%d: databases: want %v, got %v",
+			i, test.Want.Databases, got.Databases)
+		if len(test.Want.Databases) > 0 {
+			tu.Equals(t, test.Want.Databases, got.Databases)
+		}
+		tu.Assert(t, len(got.Tables) == len(test.Want.Tables), "#%d: tables: want %d, got %d",
+			i, len(test.Want.Tables), len(got.Tables))
+		for j, table := range test.Want.Tables {
+			tu.Equals(t, table, got.Tables[j])
+		}
+		tu.Assert(t, got.ColumnsKnown == test.Want.ColumnsKnown, "#%d: columns known: want %v, got %v",
+			i, test.Want.ColumnsKnown, got.ColumnsKnown)
+	}
+}
+
+func TestUseDatabase(t *testing.T) {
+	tests := []struct {
+		Query string
+		DB    string
+		OK    bool
+	}{
+		{Query: "USE sakila", DB: "sakila", OK: true},
+		{Query: "use `my db`;", DB: "my db", OK: true},
+		{Query: "  Use shop ; ", DB: "shop", OK: true},
+		{Query: "SELECT * FROM users", OK: false},
+		{Query: "USE sakila; SELECT 1", OK: false},
+	}
+	for _, test := range tests {
+		db, ok := UseDatabase(test.Query)
+		tu.Equals(t, ok, test.OK)
+		tu.Equals(t, db, test.DB)
+	}
+}
+
+func TestParseSession(t *testing.T) {
+	statements := []string{
+		"SET @x = 1",
+		"SELECT id, name FROM film WHERE id = @x",
+		"USE shop",
+		"SELECT total FROM orders WHERE id = 1",
+		"SELECT title FROM sakila.film",
+	}
+	got := ParseSession(statements, "sakila")
+	tu.Equals(t, got.Databases, []string{"sakila", "shop"})
+	tu.Equals(t, len(got.Tables), 2)
+	tu.Equals(t, *got.Tables[0], Table{Database: "sakila", Name: "film", Columns: []string{"id", "name", "title"}})
+	tu.Equals(t, *got.Tables[1], Table{Database: "shop", Name: "orders", Columns: []string{"total", "id"}})
+	tu.Assert(t, got.ColumnsKnown, "Columns must be known")
+
+	got = ParseSession([]string{"SELECT * FROM film", "SELECT id FROM sakila.actor"}, "sakila")
+	tu.Assert(t, !got.ColumnsKnown, "Columns are unknown for SELECT *")
+
+	got = ParseSession([]string{"SET @x = 1", "SELECT @x"}, "sakila")
+	tu.Equals(t, len(got.Tables), 0)
+	tu.Assert(t, !got.ColumnsKnown, "There are no columns")
+}
+
+func TestPlaceholderValues(t *testing.T) {
+	tests := []struct {
+		Query  string
+		Values []interface{}
+	}{
+		{Query: "SELECT * FROM film WHERE film_id = ?", Values: []interface{}{int64(1)}},
+		{Query: "SELECT * FROM film WHERE title LIKE ? LIMIT ?, ?", Values: []interface{}{"%", int64(1), int64(1)}},
+		{Query: "SELECT * FROM rental WHERE rental_date BETWEEN ? AND ? AND customer_id IN (?, ?)",
+			Values: []interface{}{"2000-01-01", "2000-01-01", int64(1), int64(1)}},
+		{Query: "INSERT INTO payment (customer_id, amount, payment_date, note) VALUES (?, ?, ?, ?), (?, ?, ?, ?)",
+			Values: []interface{}{int64(1), int64(1), "2000-01-01", "1", int64(1), int64(1), "2000-01-01", "1"}},
+		{Query: "UPDATE film SET title = ?, last_update = NOW() WHERE film_id = ?", Values: []interface{}{"1", int64(1)}},
+		{Query: "SELECT '?' FROM film WHERE title = 'a?' /* ? */", Values: []interface{}{}},
+	}
+	for _, test := range tests {
+		tu.Equals(t, PlaceholderValues(test.Query), test.Values)
+		tu.Equals(t, HasPlaceholders(test.Query), len(test.Values) > 0)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	got := Interpolate("SELECT '?' FROM film WHERE film_id = ? AND title LIKE ? LIMIT ?")
+	tu.Equals(t, got, "SELECT '?' FROM film WHERE film_id = 1 AND title LIKE '%' LIMIT 1")
+}
+
+func TestParseDefinition(t *testing.T) {
+	tests := []struct {
+		Stmt string
+		Want *Definition
+	}{
+		{
+			Stmt: "CREATE DEFINER=`app`@`%` PROCEDURE `add_payment`(IN p_customer INT, p_amount DECIMAL(5,2))\n" +
+				"    SQL SECURITY INVOKER\n" +
+				"BEGIN\n" +
+				"  DECLARE v_total, v_count INT;\n" +
+				"  DECLARE CONTINUE HANDLER FOR NOT FOUND SET v_count = 0;\n" +
+				"  SELECT SUM(amount), COUNT(*) INTO v_total, v_count FROM payment WHERE customer_id = p_customer;\n" +
+				"  IF (SELECT active FROM customer WHERE customer_id = p_customer) = 1 THEN\n" +
+				"    INSERT INTO payment (customer_id, amount) VALUES (p_customer, p_amount);\n" +
+				"  END IF;\n" +
+				"  SET v_total = REPLACE(v_total, ',', '');\n" +
+				"  CALL sakila.log_payment(p_customer);\n" +
+				"END",
+			Want: &Definition{
+				Kind: "PROCEDURE", Database: "sakila", Name: "add_payment", DefinerUser: "app", DefinerHost: "%",
+				Invoker: true,
+				Statements: []string{
+					"SELECT SUM(amount), COUNT(*)  FROM payment WHERE customer_id = ?",
+					"SELECT active FROM customer WHERE customer_id = ?",
+					"INSERT INTO payment (customer_id, amount) VALUES (?, ?)",
+					"CALL sakila.log_payment(?)",
+				},
+			},
+		},
+		{
+			Stmt: "CREATE DEFINER=`root`@`localhost` FUNCTION `shop`.`total`(o INT) RETURNS varchar(10) CHARSET utf8mb4\n" +
+				"    READS SQL DATA\n" +
+				"RETURN (SELECT SUM(price) FROM items WHERE order_id = o)",
+			Want: &Definition{
+				Kind: "FUNCTION", Database: "shop", Name: "total", DefinerUser: "root", DefinerHost: "localhost",
+				Statements: []string{"SELECT SUM(price) FROM items WHERE order_id = ?"},
+			},
+		},
+		{
+			Stmt: "/*!50001 CREATE ALGORITHM=UNDEFINED */ /*!50013 DEFINER=`root`@`localhost` SQL SECURITY DEFINER */ " +
+				"/*!50001 VIEW `film_list` (title, category) AS select f.title, c.name from film f join category c " +
+				"on f.category_id = c.category_id WITH CASCADED CHECK OPTION */",
+			Want: &Definition{
+				Kind: "VIEW", Database: "sakila", Name: "film_list", DefinerUser: "root", DefinerHost: "localhost",
+				Statements: []string{"select f.title, c.name from film f join category c on f.category_id = c.category_id"},
+			},
+		},
+		{
+			Stmt: "/*!50003 CREATE*/ /*!50017 DEFINER=root@localhost*/ /*!50003 TRIGGER ins_film AFTER INSERT ON film " +
+				"FOR EACH ROW BEGIN\n" +
+				"    INSERT INTO film_text (film_id, title) VALUES (new.film_id, NEW.title);\n" +
+				"  END */",
+			Want: &Definition{
+				Kind: "TRIGGER", Database: "sakila", Name: "ins_film", DefinerUser: "root", DefinerHost: "localhost",
+				Table: "film", Event: "INSERT",
+				Statements: []string{"INSERT INTO film_text (film_id, title) VALUES (?, ?)"},
+			},
+		},
+	}
+	for _, test := range tests {
+		got, ok := ParseDefinition(test.Stmt, "sakila")
+		tu.Assert(t, ok, "cannot parse %q", test.Stmt)
+		tu.Equals(t, got, test.Want)
+	}
+
+	for _, stmt := range []string{"CREATE TABLE film (id INT)", "SELECT 1", "CREATE EVENT e ON SCHEDULE EVERY 1 DAY DO SELECT 1"} {
+		_, ok := ParseDefinition(stmt, "sakila")
+		tu.Assert(t, !ok, "%q is not a routine, view or trigger definition", stmt)
+	}
+}
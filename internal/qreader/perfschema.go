@@ -0,0 +1,258 @@
+package qreader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/go-mysql/query"
+	"github.com/pkg/errors"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/qparser"
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+	"github.com/Percona-Lab/minimum_permissions/internal/utils"
+)
+
+// ReadPerformanceSchema reads an export of the performance_schema events_statements_history,
+// events_statements_history_long or events_statements_summary_by_digest tables and returns a
+// testing case for each statement digest and schema selected by the filter.
+// The export can be CSV or tab separated (mysql -B output), having the columns names in the
+// first line, or JSON: an array of objects or an object per line. The statement is read from
+// SQL_TEXT, QUERY_SAMPLE_TEXT or DIGEST_TEXT, the default database from CURRENT_SCHEMA or
+// SCHEMA_NAME, and the account from USER and HOST or PROCESSLIST_USER and PROCESSLIST_HOST,
+// available joining the threads table. The --since and --until filters use LAST_SEEN, so they
+// cannot be used with the history tables.
+// The statements are grouped by DIGEST and the SourceLine of the testing cases is the row number.
+func ReadPerformanceSchema(filename string, filter Filter) ([]*tester.TestingCase, error) {
+	filename = utils.ExpandHomeDir(filename)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot open %s", filename)
+	}
+
+	var rows []map[string]string
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{'):
+		rows, err = readJSONRows(trimmed)
+	default:
+		rows, err = readDelimitedRows(data)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot parse %s", filename)
+	}
+
+	tc := []*tester.TestingCase{}
+	byDigest := map[string]*tester.TestingCase{}
+	for i, row := range rows {
+		text, prepared := statementText(row)
+		if text == "" {
+			continue
+		}
+		db := column(row, "CURRENT_SCHEMA", "SCHEMA_NAME")
+		user := column(row, "USER", "PROCESSLIST_USER")
+		host := column(row, "HOST", "PROCESSLIST_HOST")
+		lastSeen := column(row, "LAST_SEEN")
+		// The history tables TIMER_START is relative to the server start, so the events time is unknown
+		if lastSeen == "" && (!filter.Since.IsZero() || !filter.Until.IsZero()) {
+			return nil, fmt.Errorf("Cannot filter %s by time: row %d has no LAST_SEEN column. "+
+				"--since and --until need an events_statements_summary_by_digest export", filename, i+1)
+		}
+		if !filter.Match(user, host, db, parsePerfSchemaTime(lastSeen)) {
+			continue
+		}
+
+		digest := column(row, "DIGEST")
+		fingerprint := column(row, "DIGEST_TEXT")
+		if fingerprint == "" {
+			fingerprint = query.Fingerprint(text)
+		}
+		if digest == "" {
+			digest = fingerprint
+		}
+		key := db + "\x00" + digest
+		testCase, ok := byDigest[key]
+		if !ok {
+			testCase = &tester.TestingCase{
+				Database:    db,
+				Query:       text,
+				Fingerprint: fingerprint,
+				SourceFile:  filename,
+				SourceLine:  i + 1,
+				Prepared:    prepared,
+			}
+			byDigest[key] = testCase
+			tc = append(tc, testCase)
+		}
+		if user != "" {
+			testCase.Users = addAccount(testCase.Users, tester.Account{User: user, Host: host})
+		}
+	}
+
+	return tc, nil
+}
+
+// statementText returns the statement to test: the SQL_TEXT or QUERY_SAMPLE_TEXT, having the
+// values used by the application, or else the DIGEST_TEXT. The digest is tested as a prepared
+// statement, having its ? placeholders and value lists replaced by placeholders. Digests longer
+// than max_digest_length end with ... and are skipped.
+func statementText(row map[string]string) (string, bool) {
+	if text := column(row, "SQL_TEXT", "QUERY_SAMPLE_TEXT"); text != "" {
+		return text, false
+	}
+	text := column(row, "DIGEST_TEXT")
+	if text == "" || strings.HasSuffix(text, "...") {
+		return "", false
+	}
+	// INSERT INTO `t` VALUES (...) /* , ... */ and IN (...)
+	text = strings.Replace(text, " /* , ... */", "", -1)
+	text = strings.Replace(text, "(...)", "(?)", -1)
+	return text, qparser.HasPlaceholders(text)
+}
+
+// column returns the value of the first of the columns present in the row. NULL values are
+// returned as empty strings.
+func column(row map[string]string, names ...string) string {
+	for _, name := range names {
+		if value, ok := row[name]; ok && value != "NULL" && value != `\N` {
+			return value
+		}
+	}
+	return ""
+}
+
+// parsePerfSchemaTime parses the FIRST_SEEN and LAST_SEEN columns: 2020-11-02 10:00:00.123456
+func parsePerfSchemaTime(value string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.999999", value, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// readJSONRows reads an array of objects or a sequence of objects. The columns names are
+// converted to upper case.
+func readJSONRows(data []byte) ([]map[string]string, error) {
+	objects := []map[string]interface{}{}
+	if data[0] == '[' {
+		if err := json.Unmarshal(data, &objects); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var object map[string]interface{}
+			if err := dec.Decode(&object); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			objects = append(objects, object)
+		}
+	}
+
+	rows := make([]map[string]string, 0, len(objects))
+	for _, object := range objects {
+		row := map[string]string{}
+		for name, value := range object {
+			switch v := value.(type) {
+			case nil:
+			case string:
+				row[strings.ToUpper(name)] = v
+			case float64:
+				row[strings.ToUpper(name)] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				row[strings.ToUpper(name)] = fmt.Sprint(v)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// readDelimitedRows reads CSV or, if the header has tabs, the mysql -B tab separated output.
+// The columns names are converted to upper case.
+func readDelimitedRows(data []byte) ([]map[string]string, error) {
+	header := string(data)
+	if i := strings.IndexByte(header, '\n'); i >= 0 {
+		header = header[:i]
+	}
+
+	records := [][]string{}
+	if strings.Contains(header, "\t") {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSuffix(scanner.Text(), "\r")
+			if line == "" {
+				continue
+			}
+			fields := strings.Split(line, "\t")
+			for i := range fields {
+				fields[i] = unescapeBatch(fields[i])
+			}
+			records = append(records, fields)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		var err error
+		if records, err = r.ReadAll(); err != nil {
+			return nil, err
+		}
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	names := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := map[string]string{}
+		for i, value := range record {
+			if i < len(names) {
+				row[strings.ToUpper(strings.TrimSpace(names[i]))] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// unescapeBatch unescapes the values in the mysql -B output: \n, \t, \\ and \0
+func unescapeBatch(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	b := &strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case '0':
+			b.WriteByte(0)
+		case 'N':
+			b.WriteString(`\N`)
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
@@ -2,49 +2,184 @@ package qreader
 
 import (
 	"bufio"
+	"fmt"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	slo "github.com/percona/go-mysql/log"
 	"github.com/percona/go-mysql/log/slow"
 	"github.com/percona/go-mysql/query"
 	"github.com/pkg/errors"
 
+	"github.com/Percona-Lab/minimum_permissions/internal/qparser"
 	"github.com/Percona-Lab/minimum_permissions/internal/tester"
 	"github.com/Percona-Lab/minimum_permissions/internal/utils"
 )
 
-// ReadSlowLog read and parse a slow log file and returns a list of testing cases
-func ReadSlowLog(filename string) ([]*tester.TestingCase, error) {
+// Filter selects the log events to test. Empty fields and zero times match everything.
+type Filter struct {
+	User     string
+	Host     string
+	Database string
+	Since    time.Time
+	Until    time.Time
+}
+
+// IsEmpty returns true if the filter matches all the events
+func (f Filter) IsEmpty() bool {
+	return f.User == "" && f.Host == "" && f.Database == "" && f.Since.IsZero() && f.Until.IsZero()
+}
+
+// Match returns true if an event run by user@host, in the database db at the time ts, must be
+// tested. Events having no timestamp don't match a time range.
+func (f Filter) Match(user, host, db string, ts time.Time) bool {
+	if f.User != "" && f.User != user {
+		return false
+	}
+	if f.Host != "" && f.Host != host {
+		return false
+	}
+	if f.Database != "" && f.Database != db {
+		return false
+	}
+	if !f.Since.IsZero() && (ts.IsZero() || ts.Before(f.Since)) {
+		return false
+	}
+	if !f.Until.IsZero() && (ts.IsZero() || ts.After(f.Until)) {
+		return false
+	}
+	return true
+}
+
+// timeFormats are the formats accepted by ParseTime
+var timeFormats = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	time.RFC3339Nano,
+}
+
+// ParseTime parses the --since and --until values. Times without a time zone are in local time.
+func ParseTime(value string) (time.Time, error) {
+	for _, format := range timeFormats {
+		if t, err := time.ParseInLocation(format, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q. Use YYYY-MM-DD [HH:MM[:SS]] or RFC3339", value)
+}
+
+// ReadSlowLog read and parse a slow log file and returns a list of testing cases for the
+// events selected by the filter
+func ReadSlowLog(filename string, filter Filter) ([]*tester.TestingCase, error) {
 	filename = utils.ExpandHomeDir(filename)
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	slp := slow.NewSlowLogParser(file, slo.Options{Debug: false})
+	slp := slow.NewSlowLogParser(file, slo.Options{Debug: false, DefaultLocation: time.Local})
 
 	go slp.Start()
 
 	queryGroups := make(map[string]*slo.Event)
+	// users holds the accounts that ran the queries in each group
+	users := make(map[string][]tester.Account)
 
+	// Old slow logs only have the # Time header when the second changes
+	var lastTs time.Time
 	for e := range slp.EventChan() {
-		fp := query.Fingerprint(e.Query)
-		queryGroups[fp] = e
+		if e.Ts.IsZero() {
+			e.Ts = lastTs
+		}
+		lastTs = e.Ts
+		if !filter.Match(e.User, e.Host, e.Db, e.Ts) {
+			continue
+		}
+		// The same query run in different default databases can use different tables
+		key := e.Db + "\x00" + query.Fingerprint(e.Query)
+		queryGroups[key] = e
+		if e.User != "" {
+			users[key] = addAccount(users[key], tester.Account{User: e.User, Host: e.Host})
+		}
+	}
+
+	slp.Stop()
+
+	offsets := make([]uint64, 0, len(queryGroups))
+	for _, event := range queryGroups {
+		offsets = append(offsets, event.Offset)
+	}
+	lines, err := lineNumbers(filename, offsets)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot get the queries line numbers from %s", filename)
 	}
 
 	testCases := []*tester.TestingCase{}
-	for fingerprint, event := range queryGroups {
-		testCases = append(testCases, &tester.TestingCase{Database: event.Db, Query: event.Query, Fingerprint: fingerprint})
+	for key, event := range queryGroups {
+		testCases = append(testCases, &tester.TestingCase{
+			Database:    event.Db,
+			Query:       event.Query,
+			Fingerprint: query.Fingerprint(event.Query),
+			SourceFile:  filename,
+			SourceLine:  lines[event.Offset],
+			Users:       users[key],
+		})
 	}
-	slp.Stop()
 
 	return testCases, nil
 }
 
+// addAccount adds the account to the list if it is not already there
+func addAccount(accounts []tester.Account, account tester.Account) []tester.Account {
+	for _, a := range accounts {
+		if a == account {
+			return accounts
+		}
+	}
+	return append(accounts, account)
+}
+
+// lineNumbers returns a map of byte offsets in a file to their line numbers
+func lineNumbers(filename string, offsets []uint64) (map[uint64]int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := make(map[uint64]int, len(offsets))
+	wanted := make(map[uint64]bool, len(offsets))
+	for _, offset := range offsets {
+		wanted[offset] = true
+	}
+
+	reader := bufio.NewReader(file)
+	lineStart := uint64(0)
+	for lineNumber := 1; ; lineNumber++ {
+		buf, err := reader.ReadBytes('\n')
+		lineEnd := lineStart + uint64(len(buf))
+		for offset := range wanted {
+			if offset >= lineStart && offset < lineEnd {
+				lines[offset] = lineNumber
+				delete(wanted, offset)
+			}
+		}
+		if err != nil || len(wanted) == 0 {
+			break
+		}
+		lineStart = lineEnd
+	}
+
+	return lines, nil
+}
+
 // ReadSlowLog read and parse a plain file where ALL the queries return with a semicolon
-// and returns a list of testing cases
+// and returns a list of testing cases. USE statements set the database of the next queries.
 func ReadPlainFile(filename string) ([]*tester.TestingCase, error) {
 	filename = utils.ExpandHomeDir(filename)
 	file, err := os.Open(filename)
@@ -61,16 +196,112 @@ func ReadPlainFile(filename string) ([]*tester.TestingCase, error) {
 		lines = append(lines, scanner.Text())
 	}
 
-	queries := joinQueryLines(lines)
+	queries, startLines := joinQueryLines(lines)
+
+	// database is the default database set by the last USE statement
+	database := ""
+	for i, query := range queries {
+		tc = append(tc, &tester.TestingCase{Query: query, Database: database, SourceFile: filename,
+			SourceLine: startLines[i], Prepared: qparser.HasPlaceholders(query)})
+		if db, ok := qparser.UseDatabase(query); ok {
+			database = db
+		}
+	}
+
+	return tc, nil
+}
 
-	for _, query := range queries {
-		tc = append(tc, &tester.TestingCase{Query: query})
+// connection is the state of a connection in the general log
+type connection struct {
+	user     string
+	host     string
+	database string
+	// session identifies the connection, since the thread IDs are reused after Quit
+	session int
+	// prepared is true if a Prepare command was logged for the connection
+	prepared bool
+}
+
+// connectRe parses the argument of the Connect and Change user commands:
+// app@10.0.0.1 on sakila using TCP/IP
+var connectRe = regexp.MustCompile(`^(.*)@(\S*)\s+on\s*(\S*)`)
+
+// ReadGeneralLog reads a general log file and returns the testing cases for the queries selected
+// by the filter. Each query has the user, host and default database of its connection, tracked
+// using the Connect, Change user and Init DB commands and the USE statements.
+// Prepared statements are read from the Prepare commands, having the ? placeholders. The Execute
+// commands are only read for connections without a logged Prepare, since they log the same
+// statements with the values in place of the placeholders.
+func ReadGeneralLog(filename string, filter Filter) ([]*tester.TestingCase, error) {
+	tc, _, err := readGeneralLog(filename, filter)
+	return tc, err
+}
+
+// ReadGeneralLogSessions reads a general log file and returns a testing case for each connection,
+// having all the queries selected by the filter as the session statements. Queries that only
+// make sense in sequence, like SET @x=1 and SELECT @x or CREATE TEMPORARY TABLE and then using
+// the table, are tested together. A USE statement is added when the default database changes
+// because of a Init DB command. The placeholders of the prepared statements are replaced by
+// plausible values.
+func ReadGeneralLogSessions(filename string, filter Filter) ([]*tester.TestingCase, error) {
+	queries, sessions, err := readGeneralLog(filename, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := []*tester.TestingCase{}
+	bySession := map[int]*tester.TestingCase{}
+	// database is the default database after the last statement of each session
+	database := map[int]string{}
+	for i, query := range queries {
+		// Queries not belonging to a connection, like the log header, are not tested
+		if sessions[i] < 0 {
+			continue
+		}
+		session, ok := bySession[sessions[i]]
+		if !ok {
+			session = &tester.TestingCase{
+				Database:   query.Database,
+				Users:      query.Users,
+				SourceFile: query.SourceFile,
+				SourceLine: query.SourceLine,
+			}
+			bySession[sessions[i]] = session
+			database[sessions[i]] = query.Database
+			tc = append(tc, session)
+		}
+		if query.Database != database[sessions[i]] {
+			session.Statements = append(session.Statements, "USE "+quoteIdent(query.Database))
+		}
+		statement := query.Query
+		if query.Prepared {
+			statement = qparser.Interpolate(statement)
+		}
+		session.Statements = append(session.Statements, statement)
+		database[sessions[i]] = query.Database
+		if db, ok := qparser.UseDatabase(query.Query); ok {
+			database[sessions[i]] = db
+		}
+	}
+
+	for _, session := range tc {
+		session.Query = strings.Join(session.Statements, ";\n")
+		// A single statement is tested as a regular query
+		if len(session.Statements) == 1 {
+			session.Statements = nil
+		}
 	}
 
 	return tc, nil
 }
 
-func ReadGeneralLog(filename string) ([]*tester.TestingCase, error) {
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// readGeneralLog returns the queries in the general log and the session of each one. Queries not
+// belonging to a connection have session -1.
+func readGeneralLog(filename string, filter Filter) ([]*tester.TestingCase, []int, error) {
 	exp := `(?s)\A` +
 		`(?:(\d{6}\s+\d{1,2}:\d\d:\d\d|\d{4}-\d{1,2}-\d{1,2}T\d\d:\d\d:\d\d\.\d+(?:Z|-?\d\d:\d\d)?))?` + // # Timestamp
 		`\s+` +
@@ -84,56 +315,145 @@ func ReadGeneralLog(filename string) ([]*tester.TestingCase, error) {
 	filename = utils.ExpandHomeDir(filename)
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Cannot open %s", filename)
+		return nil, nil, errors.Wrapf(err, "Cannot open %s", filename)
 	}
 	defer file.Close()
 
 	tc := []*tester.TestingCase{}
-	query := ""
+	sessions := []int{}
+	querySession := -1
+	lastSession := 0
+	// query is the query being read. Queries can span multiple lines
+	var query *tester.TestingCase
+	var queryTs time.Time
 	inAdminCmd := false
+	// Old general logs only have the timestamp when the second changes
+	var ts time.Time
+	connections := map[string]*connection{}
+
+	addQuery := func() {
+		if query == nil || query.Query == "" {
+			return
+		}
+		user, host := "", ""
+		if len(query.Users) > 0 {
+			user, host = query.Users[0].User, query.Users[0].Host
+		}
+		if filter.Match(user, host, query.Database, queryTs) {
+			tc = append(tc, query)
+			sessions = append(sessions, querySession)
+		}
+	}
 
 	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
 		line := scanner.Text()
 		m := re.FindStringSubmatch(line)
 		if len(m) > 3 {
 			// we found a new query, that signals we already parsed the previous query.
 			// append the previous one to the tests cases slice
-			if query != "" {
-				tc = append(tc, &tester.TestingCase{Query: query})
-				query = ""
+			addQuery()
+			query = nil
+			if m[1] != "" {
+				ts = parseGenlogTime(m[1])
 			}
 
-			if m[3] == "Query" {
-				query = m[4]
-				inAdminCmd = false
-				continue
+			thread, command, arg := m[2], m[3], m[4]
+			conn := connections[thread]
+			if conn == nil || command == "Connect" {
+				lastSession++
+				conn = &connection{session: lastSession}
+				connections[thread] = conn
 			}
-
 			inAdminCmd = true
+
+			if command == "Prepare" {
+				conn.prepared = true
+			}
+
+			switch {
+			case command == "Query", command == "Prepare", command == "Execute" && !conn.prepared:
+				query = &tester.TestingCase{
+					Query:      arg,
+					Database:   conn.database,
+					SourceFile: filename,
+					SourceLine: lineNumber,
+					Prepared:   command != "Query",
+				}
+				if conn.user != "" {
+					query.Users = []tester.Account{{User: conn.user, Host: conn.host}}
+				}
+				queryTs = ts
+				querySession = conn.session
+				inAdminCmd = false
+				if db, ok := qparser.UseDatabase(arg); ok {
+					conn.database = db
+				}
+			case command == "Connect", command == "Change" && strings.HasPrefix(arg, "user"):
+				arg = strings.TrimSpace(strings.TrimPrefix(arg, "user"))
+				// Failed connections log the error (Access denied for user ...) as the argument
+				if cm := connectRe.FindStringSubmatch(arg); cm != nil && !strings.Contains(arg, "Access denied") {
+					conn.user, conn.host, conn.database = cm[1], cm[2], cm[3]
+					if conn.database == "using" {
+						conn.database = ""
+					}
+				}
+			case command == "Init" && strings.HasPrefix(arg, "DB"):
+				conn.database = strings.TrimSpace(strings.TrimPrefix(arg, "DB"))
+			case command == "Quit":
+				delete(connections, thread)
+			}
 		} else {
 			if inAdminCmd {
 				continue
 			}
-			query += line
+			if query == nil {
+				query = &tester.TestingCase{SourceFile: filename, SourceLine: lineNumber}
+				queryTs = ts
+				querySession = -1
+			}
+			query.Query += line
 		}
 	}
-	if query != "" {
-		tc = append(tc, &tester.TestingCase{Query: query})
-	}
+	addQuery()
 
-	return tc, nil
+	return tc, sessions, nil
+}
+
+// parseGenlogTime parses the general log timestamps: 181014 13:37:51 (MySQL 5.6 and older, in local
+// time) or 2018-10-14T13:37:51.412899Z
+func parseGenlogTime(value string) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05.999999999", value, time.Local); err == nil {
+		return t
+	}
+	fields := strings.Fields(value)
+	if len(fields) == 2 {
+		if t, err := time.ParseInLocation("060102 15:04:05", fields[0]+" "+fields[1], time.Local); err == nil {
+			return t
+		}
+		if t, err := time.ParseInLocation("060102 15:04:05", fields[0]+" 0"+fields[1], time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
 }
 
-func joinQueryLines(lines []string) []string {
+// joinQueryLines joins the lines of multi-line queries. It returns the queries and the
+// line number where each query starts.
+func joinQueryLines(lines []string) ([]string, []int) {
 	inQuery := false
 	joined := []string{}
+	startLines := []int{}
 	queryString := ""
 	separator := ""
 
-	for _, line := range lines {
+	for i, line := range lines {
 		if !inQuery {
 			inQuery = true
+			startLines = append(startLines, i+1)
 		}
 		if inQuery {
 			queryString += separator + line
@@ -149,5 +469,5 @@ func joinQueryLines(lines []string) []string {
 		}
 		joined = append(joined, line)
 	}
-	return joined
+	return joined, startLines[:len(joined)]
 }
@@ -1,10 +1,14 @@
 package qreader
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Percona-Lab/minimum_permissions/internal/tester"
 	tu "github.com/Percona-Lab/minimum_permissions/internal/testutils"
@@ -15,11 +19,15 @@ func TestMain(m *testing.M) {
 }
 
 func TestReadGenlog(t *testing.T) {
+	file := filepath.Join(tu.BaseDir(), "testdata/genlog")
+	msandbox := []tester.Account{{User: "msandbox", Host: "localhost"}}
 	want := []*tester.TestingCase{
 		{
 			Database:         "",
 			Query:            "/home/karl/mysql/my-5.7/bin/mysqld, Version: 5.7.22-log (MySQL Community Server (GPL)). started with:Tcp port: 12345  Unix socket: /tmp/12345/mysql_sandbox12345.sockTime                 Id Command    Argument",
 			Fingerprint:      "",
+			SourceFile:       file,
+			SourceLine:       1,
 			MinimumGrants:    nil,
 			LastTestedGrants: nil,
 			NotAllowed:       false,
@@ -30,6 +38,9 @@ func TestReadGenlog(t *testing.T) {
 			Database:         "",
 			Query:            "select @@version_comment limit 1",
 			Fingerprint:      "",
+			SourceFile:       file,
+			SourceLine:       7,
+			Users:            msandbox,
 			MinimumGrants:    nil,
 			LastTestedGrants: nil,
 			NotAllowed:       false,
@@ -40,6 +51,9 @@ func TestReadGenlog(t *testing.T) {
 			Database:         "",
 			Query:            "SHOW /*!40100 ENGINE*/ INNODB STATUS",
 			Fingerprint:      "",
+			SourceFile:       file,
+			SourceLine:       8,
+			Users:            msandbox,
 			MinimumGrants:    nil,
 			LastTestedGrants: nil,
 			NotAllowed:       false,
@@ -50,6 +64,9 @@ func TestReadGenlog(t *testing.T) {
 			Database:         "",
 			Query:            "select @@version_comment limit 1",
 			Fingerprint:      "",
+			SourceFile:       file,
+			SourceLine:       11,
+			Users:            msandbox,
 			MinimumGrants:    nil,
 			LastTestedGrants: nil,
 			NotAllowed:       false,
@@ -60,6 +77,9 @@ func TestReadGenlog(t *testing.T) {
 			Database:         "",
 			Query:            "SELECT DATABASE()",
 			Fingerprint:      "",
+			SourceFile:       file,
+			SourceLine:       12,
+			Users:            msandbox,
 			MinimumGrants:    nil,
 			LastTestedGrants: nil,
 			NotAllowed:       false,
@@ -67,9 +87,12 @@ func TestReadGenlog(t *testing.T) {
 			InvalidQuery:     false,
 		},
 		{
-			Database:         "",
+			Database:         "mysql",
 			Query:            "/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */",
 			Fingerprint:      "",
+			SourceFile:       file,
+			SourceLine:       14,
+			Users:            msandbox,
 			MinimumGrants:    nil,
 			LastTestedGrants: nil,
 			NotAllowed:       false,
@@ -77,9 +100,12 @@ func TestReadGenlog(t *testing.T) {
 			InvalidQuery:     false,
 		},
 		{
-			Database:         "",
+			Database:         "mysql",
 			Query:            "DROP TABLE IF EXISTS `columns_priv`",
 			Fingerprint:      "",
+			SourceFile:       file,
+			SourceLine:       15,
+			Users:            msandbox,
 			MinimumGrants:    nil,
 			LastTestedGrants: nil,
 			NotAllowed:       false,
@@ -87,9 +113,12 @@ func TestReadGenlog(t *testing.T) {
 			InvalidQuery:     false,
 		},
 		{
-			Database:         "",
+			Database:         "mysql",
 			Query:            "/*!40101 SET @saved_cs_client     = @@character_set_client */",
 			Fingerprint:      "",
+			SourceFile:       file,
+			SourceLine:       16,
+			Users:            msandbox,
 			MinimumGrants:    nil,
 			LastTestedGrants: nil,
 			NotAllowed:       false,
@@ -97,9 +126,12 @@ func TestReadGenlog(t *testing.T) {
 			InvalidQuery:     false,
 		},
 		{
-			Database:         "",
+			Database:         "mysql",
 			Query:            "/*!40101 SET character_set_client = utf8 */",
 			Fingerprint:      "",
+			SourceFile:       file,
+			SourceLine:       17,
+			Users:            msandbox,
 			MinimumGrants:    nil,
 			LastTestedGrants: nil,
 			NotAllowed:       false,
@@ -107,9 +139,12 @@ func TestReadGenlog(t *testing.T) {
 			InvalidQuery:     false,
 		},
 		{
-			Database:         "",
+			Database:         "mysql",
 			Query:            "CREATE TABLE `columns_priv` (  `Host` char(60) COLLATE utf8_bin NOT NULL DEFAULT '',  `Db` char(64) COLLATE utf8_bin NOT NULL DEFAULT '',  `User` char(32) COLLATE utf8_bin NOT NULL DEFAULT '',  `Table_name` char(64) COLLATE utf8_bin NOT NULL DEFAULT '',  `Column_name` char(64) COLLATE utf8_bin NOT NULL DEFAULT '',  `Timestamp` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,  `Column_priv` set('Select','Insert','Update','References') CHARACTER SET utf8 NOT NULL DEFAULT '',  PRIMARY KEY (`Host`,`Db`,`User`,`Table_name`,`Column_name`)) ENGINE=MyISAM DEFAULT CHARSET=utf8 COLLATE=utf8_bin COMMENT='Column privileges'",
 			Fingerprint:      "",
+			SourceFile:       file,
+			SourceLine:       18,
+			Users:            msandbox,
 			MinimumGrants:    nil,
 			LastTestedGrants: nil,
 			NotAllowed:       false,
@@ -117,8 +152,7 @@ func TestReadGenlog(t *testing.T) {
 			InvalidQuery:     false,
 		},
 	}
-	file := filepath.Join(tu.BaseDir(), "testdata/genlog")
-	res, err := ReadGeneralLog(file)
+	res, err := ReadGeneralLog(file, Filter{})
 	if err != nil {
 		t.Errorf("Cannot parse general log file %s: %s", file, err)
 	}
@@ -126,3 +160,274 @@ func TestReadGenlog(t *testing.T) {
 		t.Errorf("Parsed queries don't match")
 	}
 }
+
+func TestReadPlainFile(t *testing.T) {
+	file := filepath.Join(tu.BaseDir(), "testdata/queries.txt")
+	want := []*tester.TestingCase{
+		{Query: "select 1;", SourceFile: file, SourceLine: 1},
+		{Query: "select 2;", SourceFile: file, SourceLine: 2},
+		{Query: "select 3,\n4;", SourceFile: file, SourceLine: 3},
+	}
+	res, err := ReadPlainFile(file)
+	tu.IsNil(t, err)
+	tu.Equals(t, res, want)
+}
+
+func TestReadSlowLogUsers(t *testing.T) {
+	file := filepath.Join(tu.BaseDir(), "testdata/slow_80_small.log")
+	testCases, err := ReadSlowLog(file, Filter{})
+	tu.Ok(t, err)
+	tu.Assert(t, len(testCases) > 0, "There must be queries in the slow log")
+	for _, tc := range testCases {
+		tu.Equals(t, tc.Users, []tester.Account{{User: "msandbox", Host: "localhost"}})
+	}
+}
+
+func TestReadSlowLogDatabases(t *testing.T) {
+	file := filepath.Join(tu.BaseDir(), "testdata/slow_use.log")
+	testCases, err := ReadSlowLog(file, Filter{})
+	tu.Ok(t, err)
+	// The same query run in sakila and in world is tested in both databases
+	databases := []string{}
+	for _, tc := range testCases {
+		tu.Equals(t, tc.Fingerprint, "select * from film where film_id = ?")
+		databases = append(databases, tc.Database)
+	}
+	sort.Strings(databases)
+	tu.Equals(t, databases, []string{"sakila", "world"})
+}
+
+func TestFilter(t *testing.T) {
+	ts := time.Date(2018, 2, 5, 2, 46, 43, 0, time.UTC)
+	tu.Assert(t, Filter{}.Match("app", "localhost", "sakila", ts), "Empty filter matches all")
+	tu.Assert(t, Filter{}.IsEmpty(), "Empty filter")
+	tu.Assert(t, Filter{User: "app", Database: "sakila"}.Match("app", "localhost", "sakila", ts), "User and db")
+	tu.Assert(t, !Filter{User: "app"}.Match("web", "localhost", "sakila", ts), "Different user")
+	tu.Assert(t, !Filter{Host: "10.0.0.1"}.Match("app", "localhost", "sakila", ts), "Different host")
+	tu.Assert(t, Filter{Since: ts}.Match("app", "localhost", "", ts), "Since is inclusive")
+	tu.Assert(t, !Filter{Since: ts.Add(time.Second)}.Match("app", "localhost", "", ts), "Before since")
+	tu.Assert(t, !Filter{Until: ts.Add(-time.Second)}.Match("app", "localhost", "", ts), "After until")
+	tu.Assert(t, !Filter{Until: ts}.Match("app", "localhost", "", time.Time{}), "No timestamp")
+}
+
+func TestParseTime(t *testing.T) {
+	got, err := ParseTime("2018-02-05 02:46:45")
+	tu.Ok(t, err)
+	tu.Equals(t, got, time.Date(2018, 2, 5, 2, 46, 45, 0, time.Local))
+
+	got, err = ParseTime("2018-02-05T02:46:45Z")
+	tu.Ok(t, err)
+	tu.Assert(t, got.Equal(time.Date(2018, 2, 5, 2, 46, 45, 0, time.UTC)), "RFC3339 time")
+
+	_, err = ParseTime("yesterday")
+	tu.NotOk(t, err)
+}
+
+func TestReadSlowLogFilter(t *testing.T) {
+	file := filepath.Join(tu.BaseDir(), "testdata/slow_80_small.log")
+	all, err := ReadSlowLog(file, Filter{})
+	tu.Ok(t, err)
+
+	since := time.Date(2018, 2, 5, 2, 46, 45, 0, time.UTC)
+	got, err := ReadSlowLog(file, Filter{Since: since})
+	tu.Ok(t, err)
+	tu.Assert(t, len(got) > 0 && len(got) < len(all), "Since must filter some events. All: %d, got: %d", len(all), len(got))
+
+	got, err = ReadSlowLog(file, Filter{User: "app"})
+	tu.Ok(t, err)
+	tu.Equals(t, len(got), 0)
+
+	got, err = ReadSlowLog(file, Filter{User: "msandbox", Host: "localhost"})
+	tu.Ok(t, err)
+	tu.Equals(t, len(got), len(all))
+}
+
+func TestReadGenlogFilter(t *testing.T) {
+	file := filepath.Join(tu.BaseDir(), "testdata/genlog")
+	got, err := ReadGeneralLog(file, Filter{Until: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)})
+	tu.Ok(t, err)
+	tu.Equals(t, len(got), 0)
+
+	all, err := ReadGeneralLog(file, Filter{})
+	tu.Ok(t, err)
+	got, err = ReadGeneralLog(file, Filter{Since: time.Date(2018, 10, 14, 0, 0, 0, 0, time.UTC)})
+	tu.Ok(t, err)
+	// The header lines before the first timestamp are not in the time range
+	tu.Equals(t, len(got), len(all)-1)
+}
+
+func TestReadGenlogUsers(t *testing.T) {
+	file := filepath.Join(tu.BaseDir(), "testdata/genlog_users")
+	app := []tester.Account{{User: "app", Host: "10.0.0.5"}}
+	web := []tester.Account{{User: "web", Host: "10.0.0.6"}}
+	report := []tester.Account{{User: "report", Host: "10.0.0.5"}}
+	want := []*tester.TestingCase{
+		{
+			Query: "/usr/sbin/mysqld, Version: 8.0.22 (MySQL Community Server - GPL). started with:" +
+				"Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sockTime                 Id Command    Argument",
+			SourceFile: file,
+			SourceLine: 1,
+		},
+		{Query: "SELECT * FROM film", Database: "sakila", Users: app, SourceFile: file, SourceLine: 6},
+		{Query: "SELECT 1", Users: web, SourceFile: file, SourceLine: 7},
+		{Query: "SELECT * FROM orders", Database: "shop", Users: web, SourceFile: file, SourceLine: 9},
+		{Query: "SELECT COUNT(*)  FROM rentals", Database: "sakila_reports", Users: report, SourceFile: file, SourceLine: 12},
+		{Query: "use `shop2`", Database: "shop", Users: web, SourceFile: file, SourceLine: 15},
+		{Query: "SELECT * FROM carts", Database: "shop2", Users: web, SourceFile: file, SourceLine: 16},
+	}
+	got, err := ReadGeneralLog(file, Filter{})
+	tu.Ok(t, err)
+	tu.Equals(t, got, want)
+
+	got, err = ReadGeneralLog(file, Filter{User: "web", Database: "shop"})
+	tu.Ok(t, err)
+	tu.Equals(t, got, []*tester.TestingCase{want[3], want[5]})
+}
+
+func TestReadPlainFileUse(t *testing.T) {
+	file, err := ioutil.TempFile("", "queries")
+	tu.Ok(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("SELECT 1;\nUSE sakila;\nSELECT * FROM film;\nuse `shop`;\nSELECT *\nFROM orders;\n")
+	tu.Ok(t, err)
+	file.Close()
+
+	got, err := ReadPlainFile(file.Name())
+	tu.Ok(t, err)
+	dbs := []string{}
+	for _, tc := range got {
+		dbs = append(dbs, tc.Database)
+	}
+	tu.Equals(t, dbs, []string{"", "", "sakila", "sakila", "shop"})
+}
+
+func TestReadGenlogSessions(t *testing.T) {
+	file := filepath.Join(tu.BaseDir(), "testdata/genlog_users")
+	appStatements := []string{"SELECT * FROM film", "USE `sakila_reports`", "SELECT COUNT(*)  FROM rentals"}
+	webStatements := []string{"SELECT 1", "USE `shop`", "SELECT * FROM orders", "use `shop2`", "SELECT * FROM carts"}
+	want := []*tester.TestingCase{
+		{
+			Query:      strings.Join(appStatements, ";\n"),
+			Statements: appStatements,
+			Database:   "sakila",
+			Users:      []tester.Account{{User: "app", Host: "10.0.0.5"}},
+			SourceFile: file,
+			SourceLine: 6,
+		},
+		{
+			Query:      strings.Join(webStatements, ";\n"),
+			Statements: webStatements,
+			Users:      []tester.Account{{User: "web", Host: "10.0.0.6"}},
+			SourceFile: file,
+			SourceLine: 7,
+		},
+	}
+	got, err := ReadGeneralLogSessions(file, Filter{})
+	tu.Ok(t, err)
+	tu.Equals(t, got, want)
+
+	// A session having a single statement is a regular query
+	got, err = ReadGeneralLogSessions(file, Filter{Database: "sakila"})
+	tu.Ok(t, err)
+	tu.Equals(t, got, []*tester.TestingCase{
+		{Query: "SELECT * FROM film", Database: "sakila", Users: want[0].Users, SourceFile: file, SourceLine: 6},
+	})
+}
+
+func TestReadGenlogPrepared(t *testing.T) {
+	file := filepath.Join(tu.BaseDir(), "testdata/genlog_prepared")
+	got, err := ReadGeneralLog(file, Filter{User: "app"})
+	tu.Ok(t, err)
+	tu.Equals(t, got, []*tester.TestingCase{
+		{
+			Query:      "SELECT title FROM film WHERE film_id = ?",
+			Database:   "sakila",
+			Users:      []tester.Account{{User: "app", Host: "10.0.0.5"}},
+			SourceFile: file,
+			SourceLine: 5,
+			Prepared:   true,
+		},
+	})
+
+	// The Execute commands are read when the Prepare isn't in the log
+	got, err = ReadGeneralLog(file, Filter{})
+	tu.Ok(t, err)
+	tu.Equals(t, len(got), 4)
+	tu.Equals(t, got[2].Query, "SELECT name FROM category WHERE category_id = 3")
+	tu.Assert(t, got[2].Prepared, "the Execute command must be tested as a prepared statement")
+	tu.Assert(t, !got[3].Prepared, "the Query command must not be tested as a prepared statement")
+
+	got, err = ReadGeneralLogSessions(file, Filter{User: "app"})
+	tu.Ok(t, err)
+	tu.Equals(t, len(got), 1)
+	tu.Equals(t, got[0].Query, "SELECT title FROM film WHERE film_id = 1")
+}
+
+func TestReadPerformanceSchemaHistory(t *testing.T) {
+	file := filepath.Join(tu.BaseDir(), "testdata/pfs_history.tsv")
+	got, err := ReadPerformanceSchema(file, Filter{})
+	tu.Ok(t, err)
+	tu.Equals(t, got, []*tester.TestingCase{
+		{
+			Database:    "sakila",
+			Query:       "SELECT * FROM film WHERE film_id = 1",
+			Fingerprint: "SELECT * FROM `film` WHERE `film_id` = ?",
+			SourceFile:  file,
+			SourceLine:  1,
+			Users:       []tester.Account{{User: "app", Host: "10.0.0.5"}, {User: "web", Host: "10.0.0.6"}},
+		},
+		{
+			Database:    "shop",
+			Query:       "UPDATE orders\n  SET total = 0 WHERE note = 'a\tb'",
+			Fingerprint: "UPDATE `orders` SET `total` = ? WHERE `note` = ?",
+			SourceFile:  file,
+			SourceLine:  4,
+			Users:       []tester.Account{{User: "web", Host: "10.0.0.6"}},
+		},
+	})
+
+	got, err = ReadPerformanceSchema(file, Filter{User: "web", Database: "sakila"})
+	tu.Ok(t, err)
+	tu.Equals(t, len(got), 1)
+	tu.Equals(t, got[0].Query, "SELECT * FROM film WHERE film_id = 3")
+
+	// The history has no events time
+	_, err = ReadPerformanceSchema(file, Filter{Since: time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)})
+	tu.NotOk(t, err)
+}
+
+func TestReadPerformanceSchemaDigest(t *testing.T) {
+	file := filepath.Join(tu.BaseDir(), "testdata/pfs_digest.json")
+	got, err := ReadPerformanceSchema(file, Filter{})
+	tu.Ok(t, err)
+	tu.Equals(t, len(got), 2)
+	tu.Equals(t, got[0].Query, "SELECT `title` FROM `film` WHERE `film_id` IN (?) LIMIT ?")
+	tu.Equals(t, got[0].Database, "sakila")
+	tu.Assert(t, got[0].Prepared, "the digest text must be tested as a prepared statement")
+	tu.Equals(t, got[1].Query, "INSERT INTO orders VALUES (1, 10), (2, 20)")
+	tu.Assert(t, !got[1].Prepared, "the query sample must not be tested as a prepared statement")
+
+	since, err := ParseTime("2020-11-03")
+	tu.Ok(t, err)
+	got, err = ReadPerformanceSchema(file, Filter{Since: since})
+	tu.Ok(t, err)
+	tu.Equals(t, len(got), 1)
+	tu.Equals(t, got[0].Database, "shop")
+}
+
+func TestReadPerformanceSchemaCSV(t *testing.T) {
+	file, err := ioutil.TempFile("", "pfs")
+	tu.Ok(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("sql_text,current_schema,user,host\n" +
+		"\"SELECT \"\"a\"\", b\nFROM t\",sakila,app,%\n" +
+		"NULL,sakila,app,%\n")
+	tu.Ok(t, err)
+	file.Close()
+
+	got, err := ReadPerformanceSchema(file.Name(), Filter{})
+	tu.Ok(t, err)
+	tu.Equals(t, len(got), 1)
+	tu.Equals(t, got[0].Query, "SELECT \"a\", b\nFROM t")
+	tu.Equals(t, got[0].Users, []tester.Account{{User: "app", Host: "%"}})
+}
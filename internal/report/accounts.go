@@ -0,0 +1,60 @@
+package report
+
+import (
+	"io"
+	"text/template"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/audit"
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+)
+
+// AccountGrants are the merged minimum grants for the queries run by an account
+type AccountGrants struct {
+	Account string
+	Queries int
+	Grants  []tester.Grant
+}
+
+// GroupByAccount merges the minimum grants of the queries run by each account, as read from
+// the logs. Queries run by several accounts are included in all of them and queries without
+// account are not included.
+func GroupByAccount(results []*tester.TestingCase) []AccountGrants {
+	groups := []AccountGrants{}
+	for _, account := range audit.Accounts(results) {
+		queries := []*tester.TestingCase{}
+		for _, res := range results {
+			for _, u := range res.Users {
+				if u == account {
+					queries = append(queries, res)
+					break
+				}
+			}
+		}
+		groups = append(groups, AccountGrants{
+			Account: account.String(),
+			Queries: len(queries),
+			Grants:  MergeGrants(queries),
+		})
+	}
+	return groups
+}
+
+// PrintAccountsReport prints the minimum grants needed by each account
+func PrintAccountsReport(results []*tester.TestingCase, w io.Writer) error {
+	report := `### Grants by account ------------------------------------------------------------------------------
+{{- range . }}
+
+{{ .Account }} ({{ .Queries }} queries)
+{{- range .Grants }}
+    {{ . }}
+{{- else }}
+    USAGE ON *.*
+{{- end }}
+{{- else }}
+
+The accounts that ran the queries are unknown
+{{- end }}
+`
+	t := template.Must(template.New("accounts").Parse(report))
+	return t.Execute(w, GroupByAccount(results))
+}
@@ -0,0 +1,115 @@
+package report
+
+import (
+	"io"
+	"text/template"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/audit"
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+)
+
+// AuditReport is the structured version of the audit report
+type AuditReport struct {
+	Accounts []AccountAuditResult `json:"accounts" yaml:"accounts"`
+}
+
+// AccountAuditResult holds the audit results for an account
+type AccountAuditResult struct {
+	Account    string            `json:"account" yaml:"account"`
+	Queries    int               `json:"queries" yaml:"queries"`
+	Grants     []GrantResult     `json:"grants" yaml:"grants"`
+	Roles      []RoleGrantResult `json:"roles" yaml:"roles"`
+	Required   []GrantResult     `json:"required_grants" yaml:"required_grants"`
+	Excess     []GrantResult     `json:"excess_privileges" yaml:"excess_privileges"`
+	RoleExcess []RoleGrantResult `json:"role_excess_privileges" yaml:"role_excess_privileges"`
+	Missing    []GrantResult     `json:"missing_privileges" yaml:"missing_privileges"`
+	Statements []string          `json:"statements" yaml:"statements"`
+}
+
+// RoleGrantResult holds the grants of a role granted to the audited account
+type RoleGrantResult struct {
+	Role   string        `json:"role" yaml:"role"`
+	Grants []GrantResult `json:"grants" yaml:"grants"`
+}
+
+// NewAuditReport builds the structured report from the audit results
+func NewAuditReport(audits []*audit.AccountAudit) *AuditReport {
+	r := &AuditReport{Accounts: []AccountAuditResult{}}
+	for _, a := range audits {
+		statements := a.Statements
+		if statements == nil {
+			statements = []string{}
+		}
+		r.Accounts = append(r.Accounts, AccountAuditResult{
+			Account:    a.Account.String(),
+			Queries:    a.Queries,
+			Grants:     grantResults(a.Grants),
+			Roles:      roleGrantResults(a.Roles),
+			Required:   grantResults(a.Required),
+			Excess:     grantResults(a.Excess),
+			RoleExcess: roleGrantResults(a.RoleExcess),
+			Missing:    grantResults(a.Missing),
+			Statements: statements,
+		})
+	}
+	return r
+}
+
+func grantResults(grants []tester.Grant) []GrantResult {
+	results := []GrantResult{}
+	for _, grant := range grants {
+		results = append(results, GrantResult{
+			Privileges: grant.Privileges,
+			On:         grant.On(),
+			Columns:    grant.Columns,
+		})
+	}
+	return results
+}
+
+func roleGrantResults(roles []audit.RoleGrants) []RoleGrantResult {
+	results := []RoleGrantResult{}
+	for _, role := range roles {
+		results = append(results, RoleGrantResult{Role: role.Role, Grants: grantResults(role.Grants)})
+	}
+	return results
+}
+
+// PrintAuditReport prints, for each account, the excess privileges, the missing ones and the
+// statements to revoke the excess privileges
+func PrintAuditReport(audits []*audit.AccountAudit, w io.Writer) error {
+	report := `{{ range . -}}
+### Account: {{ .Account }} ({{ .Queries }} queries)
+
+Excess privileges:
+{{- range .Excess }}
+    {{ . }}
+{{- end }}
+{{- range $role := .RoleExcess }}
+{{- range .Grants }}
+    {{ . }} (role {{ $role.Role }})
+{{- end }}
+{{- end }}
+{{- if and (not .Excess) (not .RoleExcess) }}
+    none
+{{- end }}
+{{- if .Missing }}
+
+Missing privileges:
+{{- range .Missing }}
+    {{ . }}
+{{- end }}
+{{- end }}
+{{- if .Statements }}
+
+Suggested statements:
+{{- range .Statements }}
+{{ . }}
+{{- end }}
+{{- end }}
+
+{{ end -}}
+`
+	t := template.Must(template.New("audit").Parse(report))
+	return t.Execute(w, audits)
+}
@@ -0,0 +1,104 @@
+package report
+
+import (
+	"io"
+	"text/template"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+)
+
+// CheckReport is the structured version of the check mode report
+type CheckReport struct {
+	// Passed is the number of queries that can run with the checked grants
+	Passed         int           `json:"passed" yaml:"passed"`
+	FailedQueries  []QueryResult `json:"failed_queries" yaml:"failed_queries"`
+	InvalidQueries []QueryResult `json:"invalid_queries" yaml:"invalid_queries"`
+	// ErrorQueries could not be tested, so it is unknown if they can run with the grants
+	ErrorQueries []QueryResult `json:"error_queries" yaml:"error_queries"`
+	// Untested is the number of queries not tested because the check was interrupted
+	Untested int `json:"untested" yaml:"untested"`
+	// Interrupted is true if the check was stopped. The unused privileges are not checked then.
+	Interrupted      bool          `json:"interrupted" yaml:"interrupted"`
+	UnusedPrivileges []GrantResult `json:"unused_privileges" yaml:"unused_privileges"`
+}
+
+// NewCheckReport builds the structured report from the check results
+func NewCheckReport(res *tester.CheckResult) *CheckReport {
+	r := &CheckReport{
+		Passed:           len(res.Passed),
+		FailedQueries:    []QueryResult{},
+		InvalidQueries:   []QueryResult{},
+		ErrorQueries:     []QueryResult{},
+		Untested:         len(res.Untested),
+		Interrupted:      res.Interrupted,
+		UnusedPrivileges: []GrantResult{},
+	}
+	for _, tc := range res.Failed {
+		r.FailedQueries = append(r.FailedQueries, newQueryResult(tc))
+	}
+	for _, tc := range res.Invalid {
+		r.InvalidQueries = append(r.InvalidQueries, newQueryResult(tc))
+	}
+	for _, tc := range res.Errors {
+		r.ErrorQueries = append(r.ErrorQueries, newQueryResult(tc))
+	}
+	for _, grant := range res.Unused {
+		r.UnusedPrivileges = append(r.UnusedPrivileges, GrantResult{
+			Privileges: grant.Privileges,
+			On:         grant.On(),
+			Columns:    grant.Columns,
+		})
+	}
+	return r
+}
+
+// PrintCheckReport prints the queries that would fail with the checked grants and the privileges
+// that no query needs
+func PrintCheckReport(res *tester.CheckResult, w io.Writer) error {
+	report := `### Check results ----------------------------------------------------------------------------------
+Queries that can run with the grants: {{ len .Passed }}
+Queries that would fail             : {{ len .Failed }}
+{{- if .Errors }}
+Queries that could not be tested    : {{ len .Errors }}
+{{- end }}
+{{- if .Untested }}
+Queries not tested (interrupted)    : {{ len .Untested }}
+{{- end }}
+{{- if .Failed }}
+
+### Failing queries --------------------------------------------------------------------------------
+{{- range .Failed }}
+{{ .Query }}
+    Error: {{ .Error }}
+{{- end }}
+{{- end }}
+{{- if .Invalid }}
+
+### Invalid queries --------------------------------------------------------------------------------
+{{- range .Invalid }}
+{{ .Query }}
+{{- end }}
+{{- end }}
+{{- if .Errors }}
+
+### Queries that could not be tested ---------------------------------------------------------------
+{{- range .Errors }}
+{{ .Query }}
+    Error: {{ .Error }}
+{{- end }}
+{{- end }}
+
+### Unused privileges ------------------------------------------------------------------------------
+{{- if .Interrupted }}
+Not checked: the check was interrupted
+{{- else }}
+{{- range .Unused }}
+{{ . }}
+{{- else }}
+All the privileges are used by at least one query
+{{- end }}
+{{- end }}
+`
+	t := template.Must(template.New("check").Parse(report))
+	return t.Execute(w, res)
+}
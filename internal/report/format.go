@@ -0,0 +1,103 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+)
+
+// Report is the structured version of the report, used for the JSON and YAML output formats
+type Report struct {
+	Queries []QueryResult `json:"queries" yaml:"queries"`
+	// Risk is the overall risk score of the privileges needed by all the queries
+	Risk int `json:"risk" yaml:"risk"`
+}
+
+// QueryResult holds the test results for a query
+type QueryResult struct {
+	Query         string        `json:"query" yaml:"query"`
+	Fingerprint   string        `json:"fingerprint,omitempty" yaml:"fingerprint,omitempty"`
+	Database      string        `json:"database,omitempty" yaml:"database,omitempty"`
+	Users         []string      `json:"users,omitempty" yaml:"users,omitempty"`
+	MinimumGrants []string      `json:"minimum_grants" yaml:"minimum_grants"`
+	ObjectGrants  []GrantResult `json:"object_grants,omitempty" yaml:"object_grants,omitempty"`
+	Alternatives  [][]string    `json:"alternative_grants,omitempty" yaml:"alternative_grants,omitempty"`
+	Risk          int           `json:"risk" yaml:"risk"`
+	LastError     string        `json:"last_error,omitempty" yaml:"last_error,omitempty"`
+	InvalidQuery  bool          `json:"invalid_query" yaml:"invalid_query"`
+	SourceFile    string        `json:"source_file,omitempty" yaml:"source_file,omitempty"`
+	SourceLine    int           `json:"source_line,omitempty" yaml:"source_line,omitempty"`
+}
+
+// GrantResult is a list of privileges granted at a specific level
+type GrantResult struct {
+	Privileges []string `json:"privileges" yaml:"privileges"`
+	On         string   `json:"on" yaml:"on"`
+	Columns    []string `json:"columns,omitempty" yaml:"columns,omitempty"`
+}
+
+// NewReport builds the structured report from the testing results and the invalid queries
+func NewReport(results, invalidQueries []*tester.TestingCase) *Report {
+	r := &Report{Queries: []QueryResult{}, Risk: WorkloadRisk(results)}
+
+	for _, tc := range results {
+		r.Queries = append(r.Queries, newQueryResult(tc))
+	}
+	for _, tc := range invalidQueries {
+		r.Queries = append(r.Queries, newQueryResult(tc))
+	}
+
+	return r
+}
+
+func newQueryResult(tc *tester.TestingCase) QueryResult {
+	qr := QueryResult{
+		Query:         tc.Query,
+		Fingerprint:   tc.Fingerprint,
+		Database:      tc.Database,
+		MinimumGrants: tc.MinimumGrants,
+		Alternatives:  tc.AlternativeGrants,
+		Risk:          tester.GrantsRisk(tc.MinimumGrants),
+		InvalidQuery:  tc.InvalidQuery,
+		SourceFile:    tc.SourceFile,
+		SourceLine:    tc.SourceLine,
+	}
+	for _, u := range tc.Users {
+		qr.Users = append(qr.Users, u.String())
+	}
+	if qr.MinimumGrants == nil {
+		qr.MinimumGrants = []string{}
+	}
+	if tc.Error != nil {
+		qr.LastError = tc.Error.Error()
+	}
+	for _, grant := range tc.ObjectGrants {
+		qr.ObjectGrants = append(qr.ObjectGrants, GrantResult{
+			Privileges: grant.Privileges,
+			On:         grant.On(),
+			Columns:    grant.Columns,
+		})
+	}
+
+	return qr
+}
+
+// PrintJSON writes the report (a Report or a VersionsReport) in JSON format
+func PrintJSON(r interface{}, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// PrintYAML writes the report (a Report or a VersionsReport) in YAML format
+func PrintYAML(r interface{}, w io.Writer) error {
+	buf, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
@@ -12,7 +12,7 @@ func GroupResults(results []*tester.TestingCase) map[string][]string {
 	rg := map[string][]string{}
 
 	for _, res := range results {
-		key := strings.Join(res.MinimumGrants, ", ")
+		key := grantsKey(res)
 		if _, ok := rg[key]; ok {
 			rg[key] = append(rg[key], res.Query)
 			continue
@@ -23,6 +23,28 @@ func GroupResults(results []*tester.TestingCase) map[string][]string {
 	return rg
 }
 
+// grantsKey returns the grants needed to run a query. If the grants were narrowed to the
+// objects used by the query, each grant includes its level, like SELECT ON `sakila`.`film`.
+// Alternative grants are listed after the grants: SUPER (alternatives: CONNECTION_ADMIN)
+func grantsKey(tc *tester.TestingCase) string {
+	key := strings.Join(tc.MinimumGrants, ", ")
+	if len(tc.ObjectGrants) > 0 {
+		grants := make([]string, 0, len(tc.ObjectGrants))
+		for _, grant := range tc.ObjectGrants {
+			grants = append(grants, grant.String())
+		}
+		key = strings.Join(grants, "; ")
+	}
+	if len(tc.AlternativeGrants) > 0 {
+		alternatives := make([]string, 0, len(tc.AlternativeGrants))
+		for _, alt := range tc.AlternativeGrants {
+			alternatives = append(alternatives, strings.Join(alt, ", "))
+		}
+		key += " (alternatives: " + strings.Join(alternatives, " | ") + ")"
+	}
+	return key
+}
+
 func stripCtlFromUTF8(str string) string {
 	return strings.Map(func(r rune) rune {
 		if r >= 32 && r != 127 {
@@ -52,7 +74,7 @@ Grants : {{ $index }}
 func PrintInvalidQueries(iq []*tester.TestingCase, w io.Writer) error {
 	report := `### Invalid Queries --------------------------------------------------------------------------------
 {{ range . }}
-{{ .Query }}: {{ .LastError }}
+{{ .Query }}: {{ .Error }}
 {{ end}}
 `
 	t := template.Must(template.New("report").Parse(report))
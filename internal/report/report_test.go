@@ -2,9 +2,16 @@ package report
 
 import (
 	"bytes"
+	"fmt"
+	"strings"
 	"testing"
 
 	tu "github.com/Percona-Lab/pt-mysql-config-diff/testutils"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/audit"
+	"github.com/Percona-Lab/minimum_permissions/internal/qparser"
+	"github.com/Percona-Lab/minimum_permissions/internal/routines"
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
 )
 
 func TestReport(t *testing.T) {
@@ -26,3 +33,458 @@ func TestReport(t *testing.T) {
 
 	tu.Assert(t, buf.String() != want, "Invalid report output")
 }
+
+func TestGroupResultsObjectGrants(t *testing.T) {
+	results := []*tester.TestingCase{
+		{Query: "SELECT 1", MinimumGrants: []string{"SELECT"}},
+		{
+			Query:         "SELECT title FROM sakila.film",
+			MinimumGrants: []string{"SELECT"},
+			ObjectGrants: []tester.Grant{
+				{Privileges: []string{"SELECT"}, Database: "sakila", Table: "film", Columns: []string{"title"}},
+			},
+		},
+		{
+			Query:         "SHOW /*!40100 ENGINE*/ INNODB STATUS",
+			MinimumGrants: []string{"SELECT", "PROCESS"},
+			ObjectGrants: []tester.Grant{
+				{Privileges: []string{"PROCESS"}},
+				{Privileges: []string{"SELECT"}, Database: "test"},
+			},
+		},
+	}
+	want := map[string][]string{
+		"SELECT":                              {"SELECT 1"},
+		"SELECT (`title`) ON `sakila`.`film`": {"SELECT title FROM sakila.film"},
+		"PROCESS ON *.*; SELECT ON `test`.*":  {"SHOW /*!40100 ENGINE*/ INNODB STATUS"},
+	}
+
+	tu.Equals(t, GroupResults(results), want)
+}
+
+func TestGroupResultsAlternatives(t *testing.T) {
+	results := []*tester.TestingCase{
+		{Query: "KILL 10", MinimumGrants: []string{"SUPER"}, AlternativeGrants: [][]string{{"CONNECTION_ADMIN"}}},
+		{Query: "KILL 11", MinimumGrants: []string{"SUPER"}, AlternativeGrants: [][]string{{"CONNECTION_ADMIN"}}},
+		{Query: "SHOW PROCESSLIST", MinimumGrants: []string{"PROCESS"}},
+		{
+			Query:             "SHOW ENGINE INNODB STATUS",
+			MinimumGrants:     []string{"PROCESS", "SELECT"},
+			AlternativeGrants: [][]string{{"PROCESS", "INSERT"}, {"PROCESS", "UPDATE"}},
+		},
+	}
+	want := map[string][]string{
+		"SUPER (alternatives: CONNECTION_ADMIN)": {"KILL 10", "KILL 11"},
+		"PROCESS":                                {"SHOW PROCESSLIST"},
+		"PROCESS, SELECT (alternatives: PROCESS, INSERT | PROCESS, UPDATE)": {"SHOW ENGINE INNODB STATUS"},
+	}
+
+	tu.Equals(t, GroupResults(results), want)
+}
+
+func TestPrintGrantsScript(t *testing.T) {
+	results := []*tester.TestingCase{
+		{Query: "SELECT 1", MinimumGrants: []string{"USAGE"}},
+		{Query: "SHOW /*!40100 ENGINE*/ INNODB STATUS", MinimumGrants: []string{"PROCESS"}},
+		{
+			Query:         "SELECT title FROM sakila.film",
+			MinimumGrants: []string{"SELECT"},
+			ObjectGrants: []tester.Grant{
+				{Privileges: []string{"SELECT"}, Database: "sakila", Table: "film", Columns: []string{"title"}},
+			},
+		},
+		{
+			Query:         "UPDATE sakila.film SET title = 'x' WHERE film_id = 1",
+			MinimumGrants: []string{"SELECT", "UPDATE"},
+			ObjectGrants: []tester.Grant{
+				{Privileges: []string{"SELECT", "UPDATE"}, Database: "sakila", Table: "film",
+					Columns: []string{"title", "film_id"}},
+			},
+		},
+		{
+			Query:         "DELETE FROM sakila.actor",
+			MinimumGrants: []string{"DELETE"},
+			ObjectGrants: []tester.Grant{
+				{Privileges: []string{"DELETE"}, Database: "sakila", Table: "actor"},
+			},
+		},
+		{
+			Query:         "INSERT INTO sakila.actor (id) VALUES (1)",
+			MinimumGrants: []string{"INSERT"},
+			ObjectGrants: []tester.Grant{
+				{Privileges: []string{"INSERT"}, Database: "sakila"},
+			},
+		},
+		{
+			Query:         "DELETE FROM sakila.film",
+			MinimumGrants: []string{"DELETE"},
+			ObjectGrants: []tester.Grant{
+				{Privileges: []string{"DELETE"}, Database: "sakila", Table: "film"},
+			},
+		},
+	}
+	want := "CREATE USER IF NOT EXISTS 'app'@'10.%';\n" +
+		"GRANT PROCESS ON *.* TO 'app'@'10.%';\n" +
+		"GRANT INSERT ON `sakila`.* TO 'app'@'10.%';\n" +
+		"GRANT DELETE ON `sakila`.`actor` TO 'app'@'10.%';\n" +
+		"GRANT DELETE ON `sakila`.`film` TO 'app'@'10.%';\n" +
+		"GRANT SELECT (`film_id`, `title`), UPDATE (`film_id`, `title`) ON `sakila`.`film` TO 'app'@'10.%';\n"
+
+	buf := new(bytes.Buffer)
+	err := PrintGrantsScript(results, "app", "10.%", buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, buf.String(), want)
+}
+
+func TestPrintGrantsScriptAlternatives(t *testing.T) {
+	results := []*tester.TestingCase{
+		{Query: "SHOW ENGINE INNODB STATUS", MinimumGrants: []string{"PROCESS"}},
+		{Query: "KILL 12", MinimumGrants: []string{"SUPER"}, AlternativeGrants: [][]string{{"CONNECTION_ADMIN"}}},
+	}
+	want := "-- These queries can also run with the alternative grants, not used in this script:\n" +
+		"--     KILL 12: SUPER (alternatives: CONNECTION_ADMIN)\n" +
+		"CREATE USER IF NOT EXISTS 'app'@'%';\n" +
+		"GRANT PROCESS, SUPER ON *.* TO 'app'@'%';\n"
+
+	buf := new(bytes.Buffer)
+	err := PrintGrantsScript(results, "app", "%", buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, buf.String(), want)
+}
+
+func TestPrintJSON(t *testing.T) {
+	results := []*tester.TestingCase{
+		{
+			Query:         "SELECT title FROM sakila.film",
+			Fingerprint:   "select title from sakila.film",
+			MinimumGrants: []string{"SELECT"},
+			ObjectGrants: []tester.Grant{
+				{Privileges: []string{"SELECT"}, Database: "sakila", Table: "film", Columns: []string{"title"}},
+			},
+			SourceFile: "slow.log",
+			SourceLine: 12,
+		},
+	}
+	invalid := []*tester.TestingCase{
+		{Query: "SELEC 1", InvalidQuery: true, Error: fmt.Errorf("syntax error")},
+	}
+	want := `{
+  "queries": [
+    {
+      "query": "SELECT title FROM sakila.film",
+      "fingerprint": "select title from sakila.film",
+      "minimum_grants": [
+        "SELECT"
+      ],
+      "object_grants": [
+        {
+          "privileges": [
+            "SELECT"
+          ],
+          "on": "` + "`sakila`.`film`" + `",
+          "columns": [
+            "title"
+          ]
+        }
+      ],
+      "risk": 1,
+      "invalid_query": false,
+      "source_file": "slow.log",
+      "source_line": 12
+    },
+    {
+      "query": "SELEC 1",
+      "minimum_grants": [],
+      "risk": 0,
+      "last_error": "syntax error",
+      "invalid_query": true
+    }
+  ],
+  "risk": 1
+}
+`
+	buf := new(bytes.Buffer)
+	err := PrintJSON(NewReport(results, invalid), buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, buf.String(), want)
+}
+
+func TestPrintYAML(t *testing.T) {
+	results := []*tester.TestingCase{
+		{Query: "SHOW /*!40100 ENGINE*/ INNODB STATUS", MinimumGrants: []string{"PROCESS"}},
+	}
+	want := `queries:
+- query: SHOW /*!40100 ENGINE*/ INNODB STATUS
+  minimum_grants:
+  - PROCESS
+  risk: 3
+  invalid_query: false
+risk: 3
+`
+	buf := new(bytes.Buffer)
+	err := PrintYAML(NewReport(results, nil), buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, buf.String(), want)
+}
+
+func TestPrintRiskReport(t *testing.T) {
+	results := []*tester.TestingCase{
+		{Query: "SELECT 1", MinimumGrants: []string{"SELECT"}},
+		{Query: "SELECT 2", MinimumGrants: []string{"SELECT"}},
+		{Query: "SHOW ENGINE INNODB STATUS", MinimumGrants: []string{"PROCESS", "SELECT"}},
+		{Query: "KILL 10", MinimumGrants: []string{"SUPER"}},
+	}
+	want := `### Risk -------------------------------------------------------------------------------------------
+
+  10 : SUPER
+   4 : PROCESS, SELECT
+   1 : SELECT
+
+Overall workload risk: 14
+`
+	buf := new(bytes.Buffer)
+	err := PrintRiskReport(results, buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, buf.String(), want)
+}
+
+func TestPrintInvalidQueries(t *testing.T) {
+	invalid := []*tester.TestingCase{
+		{Query: "SELEC 1", InvalidQuery: true, Error: fmt.Errorf("syntax error")},
+	}
+	buf := new(bytes.Buffer)
+	err := PrintInvalidQueries(invalid, buf)
+	tu.IsNil(t, err)
+	tu.Assert(t, strings.Contains(buf.String(), "SELEC 1: syntax error"), "Invalid queries output")
+}
+
+func TestPrintVersionsDiff(t *testing.T) {
+	versions := []VersionResults{
+		{
+			Version: "my-5.7",
+			Results: []*tester.TestingCase{
+				{Query: "SELECT 1", MinimumGrants: []string{"USAGE"}},
+				{Query: "SET GLOBAL max_connections = 100", MinimumGrants: []string{"SUPER"}},
+			},
+		},
+		{
+			Version: "my-8.0",
+			Results: []*tester.TestingCase{
+				{Query: "SELECT 1", MinimumGrants: []string{"USAGE"}},
+				{Query: "SET GLOBAL max_connections = 100", MinimumGrants: []string{"SYSTEM_VARIABLES_ADMIN"}},
+			},
+			InvalidQueries: []*tester.TestingCase{
+				{Query: "SELECT SQL_CACHE 1", InvalidQuery: true},
+			},
+		},
+	}
+	want := `### Grants differences between versions ------------------------------------------------------------
+
+Query: SET GLOBAL max_connections = 100
+    my-5.7 : SUPER
+    my-8.0 : SYSTEM_VARIABLES_ADMIN
+
+Query: SELECT SQL_CACHE 1
+    my-5.7 : grants not found
+    my-8.0 : invalid query
+`
+	buf := new(bytes.Buffer)
+	err := PrintVersionsDiff(versions, buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, want, buf.String())
+
+	buf.Reset()
+	err = PrintVersionsDiff(versions[:1], buf)
+	tu.IsNil(t, err)
+	tu.Assert(t, strings.Contains(buf.String(), "The minimum grants are the same in all versions"), "Versions diff output")
+}
+
+func TestPrintRolesScript(t *testing.T) {
+	results := []*tester.TestingCase{
+		{Query: "SELECT 1", MinimumGrants: []string{"USAGE"}},
+		{Query: "SHOW PROCESSLIST", MinimumGrants: []string{"PROCESS"}},
+		{
+			Query:         "SELECT title FROM sakila.film",
+			MinimumGrants: []string{"SELECT"},
+			ObjectGrants: []tester.Grant{
+				{Privileges: []string{"SELECT"}, Database: "sakila", Table: "film", Columns: []string{"title"}},
+			},
+		},
+		{
+			Query:         "SELECT title FROM sakila.film WHERE 1",
+			MinimumGrants: []string{"SELECT"},
+			ObjectGrants: []tester.Grant{
+				{Privileges: []string{"SELECT"}, Database: "sakila", Table: "film", Columns: []string{"title"}},
+			},
+		},
+	}
+	want := "-- PROCESS\n" +
+		"CREATE ROLE IF NOT EXISTS 'app_role_1';\n" +
+		"GRANT PROCESS ON *.* TO 'app_role_1';\n" +
+		"\n" +
+		"-- SELECT (`title`) ON `sakila`.`film`\n" +
+		"CREATE ROLE IF NOT EXISTS 'app_role_2';\n" +
+		"GRANT SELECT (`title`) ON `sakila`.`film` TO 'app_role_2';\n" +
+		"\n" +
+		"CREATE USER IF NOT EXISTS 'app'@'%';\n" +
+		"GRANT 'app_role_1', 'app_role_2' TO 'app'@'%';\n" +
+		"SET DEFAULT ROLE 'app_role_1', 'app_role_2' TO 'app'@'%';\n"
+
+	buf := new(bytes.Buffer)
+	err := PrintRolesScript(results, "app", "%", "app_role_", buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, want, buf.String())
+}
+
+func TestPrintCheckReport(t *testing.T) {
+	res := &tester.CheckResult{
+		Passed: []*tester.TestingCase{{Query: "SELECT 1"}},
+		Failed: []*tester.TestingCase{
+			{Query: "SHOW ENGINE INNODB STATUS", NotAllowed: true, Error: fmt.Errorf("access denied")},
+		},
+		Unused: []tester.Grant{{Privileges: []string{"INSERT"}, Database: "sakila"}},
+	}
+	want := `### Check results ----------------------------------------------------------------------------------
+Queries that can run with the grants: 1
+Queries that would fail             : 1
+
+### Failing queries --------------------------------------------------------------------------------
+SHOW ENGINE INNODB STATUS
+    Error: access denied
+
+### Unused privileges ------------------------------------------------------------------------------
+INSERT ON ` + "`sakila`.*" + `
+`
+	buf := new(bytes.Buffer)
+	err := PrintCheckReport(res, buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, buf.String(), want)
+
+	r := NewCheckReport(res)
+	tu.Equals(t, r.Passed, 1)
+	tu.Equals(t, len(r.FailedQueries), 1)
+	tu.Equals(t, r.UnusedPrivileges, []GrantResult{{Privileges: []string{"INSERT"}, On: "`sakila`.*"}})
+}
+
+func TestPrintCheckReportErrors(t *testing.T) {
+	res := &tester.CheckResult{
+		Passed:      []*tester.TestingCase{{Query: "SELECT 1"}},
+		Errors:      []*tester.TestingCase{{Query: "SELECT 2", Error: fmt.Errorf("invalid connection")}},
+		Untested:    []*tester.TestingCase{{Query: "SELECT 3"}},
+		Interrupted: true,
+	}
+	want := `### Check results ----------------------------------------------------------------------------------
+Queries that can run with the grants: 1
+Queries that would fail             : 0
+Queries that could not be tested    : 1
+Queries not tested (interrupted)    : 1
+
+### Queries that could not be tested ---------------------------------------------------------------
+SELECT 2
+    Error: invalid connection
+
+### Unused privileges ------------------------------------------------------------------------------
+Not checked: the check was interrupted
+`
+	buf := new(bytes.Buffer)
+	err := PrintCheckReport(res, buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, buf.String(), want)
+
+	r := NewCheckReport(res)
+	tu.Equals(t, len(r.ErrorQueries), 1)
+	tu.Equals(t, r.Untested, 1)
+	tu.Assert(t, r.Interrupted, "The check must be interrupted")
+}
+
+func TestPrintAuditReport(t *testing.T) {
+	audits := []*audit.AccountAudit{
+		{
+			Account:    tester.Account{User: "app", Host: "%"},
+			Queries:    2,
+			Excess:     []tester.Grant{{Privileges: []string{"SUPER"}}},
+			RoleExcess: []audit.RoleGrants{{Role: "`app_read`@`%`", Grants: []tester.Grant{{Privileges: []string{"PROCESS"}}}}},
+			Statements: []string{"REVOKE SUPER ON *.* FROM 'app'@'%';", "REVOKE PROCESS ON *.* FROM `app_read`@`%`;"},
+		},
+		{
+			Account: tester.Account{User: "web", Host: "10.%"},
+			Queries: 1,
+			Missing: []tester.Grant{{Privileges: []string{"SELECT"}, Database: "sakila"}},
+		},
+	}
+	want := "### Account: 'app'@'%' (2 queries)\n\n" +
+		"Excess privileges:\n    SUPER ON *.*\n    PROCESS ON *.* (role `app_read`@`%`)\n\n" +
+		"Suggested statements:\nREVOKE SUPER ON *.* FROM 'app'@'%';\nREVOKE PROCESS ON *.* FROM `app_read`@`%`;\n\n" +
+		"### Account: 'web'@'10.%' (1 queries)\n\n" +
+		"Excess privileges:\n    none\n\n" +
+		"Missing privileges:\n    SELECT ON `sakila`.*\n\n"
+	buf := new(bytes.Buffer)
+	err := PrintAuditReport(audits, buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, buf.String(), want)
+
+	r := NewAuditReport(audits)
+	tu.Equals(t, r.Accounts[1].Statements, []string{})
+	tu.Equals(t, r.Accounts[0].Excess, []GrantResult{{Privileges: []string{"SUPER"}, On: "*.*"}})
+	tu.Equals(t, r.Accounts[0].RoleExcess, []RoleGrantResult{
+		{Role: "`app_read`@`%`", Grants: []GrantResult{{Privileges: []string{"PROCESS"}, On: "*.*"}}},
+	})
+}
+
+func TestPrintAccountsReport(t *testing.T) {
+	app := tester.Account{User: "app", Host: "10.0.0.5"}
+	web := tester.Account{User: "web", Host: "10.0.0.6"}
+	results := []*tester.TestingCase{
+		{Query: "SELECT * FROM film", MinimumGrants: []string{"SELECT"},
+			ObjectGrants: []tester.Grant{{Privileges: []string{"SELECT"}, Database: "sakila", Table: "film"}},
+			Users:        []tester.Account{app}},
+		{Query: "SELECT 1", MinimumGrants: []string{"USAGE"}, Users: []tester.Account{app, web}},
+		{Query: "SHOW ENGINE INNODB STATUS", MinimumGrants: []string{"PROCESS"}},
+	}
+	want := "### Grants by account ------------------------------------------------------------------------------\n\n" +
+		"'app'@'10.0.0.5' (2 queries)\n    SELECT ON `sakila`.`film`\n\n" +
+		"'web'@'10.0.0.6' (1 queries)\n    USAGE ON *.*\n"
+	buf := new(bytes.Buffer)
+	err := PrintAccountsReport(results, buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, buf.String(), want)
+
+	buf.Reset()
+	err = PrintAccountsReport(results[2:], buf)
+	tu.IsNil(t, err)
+	tu.Assert(t, strings.Contains(buf.String(), "accounts that ran the queries are unknown"), "No accounts")
+}
+
+func TestPrintRoutinesReport(t *testing.T) {
+	objects := []*routines.Object{
+		{
+			Definition: &qparser.Definition{Kind: "PROCEDURE", Database: "shop", Name: "close_order",
+				DefinerUser: "app", DefinerHost: "%"},
+			InvokerGrants: []tester.Grant{{Privileges: []string{"EXECUTE"}, Database: "shop", Table: "close_order",
+				Routine: "PROCEDURE"}},
+			DefinerGrants: []tester.Grant{{Privileges: []string{"UPDATE"}, Database: "shop", Table: "orders"}},
+		},
+		{
+			Definition: &qparser.Definition{Kind: "VIEW", Database: "shop", Name: "v", DefinerUser: "app",
+				DefinerHost: "%", Invoker: true},
+			InvokerGrants: []tester.Grant{{Privileges: []string{"SELECT"}, Database: "shop", Table: "v"}},
+			DefinerGrants: []tester.Grant{},
+		},
+	}
+	want := "### Stored routines, views and triggers ------------------------------------------------------------\n\n" +
+		"PROCEDURE `shop`.`close_order` (definer 'app'@'%', SQL SECURITY DEFINER)\n" +
+		"    Invoker grants:\n        EXECUTE ON PROCEDURE `shop`.`close_order`\n" +
+		"    Definer grants:\n        UPDATE ON `shop`.`orders`\n\n" +
+		"VIEW `shop`.`v` (definer 'app'@'%', SQL SECURITY INVOKER)\n" +
+		"    Invoker grants:\n        SELECT ON `shop`.`v`\n" +
+		"    Definer grants:\n        none\n"
+	buf := new(bytes.Buffer)
+	err := PrintRoutinesReport(objects, buf)
+	tu.IsNil(t, err)
+	tu.Equals(t, buf.String(), want)
+
+	r := NewRoutinesReport(objects)
+	tu.Equals(t, r.Objects[1].SQLSecurity, "INVOKER")
+	tu.Equals(t, r.Objects[0].InvokerGrants, []GrantResult{{Privileges: []string{"EXECUTE"},
+		On: "PROCEDURE `shop`.`close_order`"}})
+}
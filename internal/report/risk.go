@@ -0,0 +1,72 @@
+package report
+
+import (
+	"io"
+	"sort"
+	"text/template"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+)
+
+// GroupRisk is the risk score of the grants needed by a group of queries
+type GroupRisk struct {
+	Grants string
+	Risk   int
+}
+
+// GroupRisks returns the risk score of each group of queries, as grouped by GroupResults,
+// sorted from the highest to the lowest risk
+func GroupRisks(results []*tester.TestingCase) []GroupRisk {
+	seen := map[string]bool{}
+	risks := []GroupRisk{}
+
+	for _, res := range results {
+		key := grantsKey(res)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		risks = append(risks, GroupRisk{Grants: key, Risk: tester.GrantsRisk(res.MinimumGrants)})
+	}
+	sort.SliceStable(risks, func(i, j int) bool {
+		if risks[i].Risk != risks[j].Risk {
+			return risks[i].Risk > risks[j].Risk
+		}
+		return risks[i].Grants < risks[j].Grants
+	})
+
+	return risks
+}
+
+// WorkloadRisk returns the risk score of all the privileges needed to run all the queries.
+// Each privilege is counted once, no matter how many queries need it.
+func WorkloadRisk(results []*tester.TestingCase) int {
+	privileges := stringSet{}
+	for _, res := range results {
+		for _, priv := range res.MinimumGrants {
+			privileges[priv] = true
+		}
+	}
+	return tester.GrantsRisk(privileges.sorted())
+}
+
+// PrintRiskReport prints the risk score of each group of queries and the overall risk score
+func PrintRiskReport(results []*tester.TestingCase, w io.Writer) error {
+	report := `### Risk -------------------------------------------------------------------------------------------
+{{ range .Groups }}
+{{ printf "%4d" .Risk }} : {{ .Grants }}
+{{- end }}
+
+Overall workload risk: {{ .Overall }}
+`
+	data := struct {
+		Groups  []GroupRisk
+		Overall int
+	}{
+		Groups:  GroupRisks(results),
+		Overall: WorkloadRisk(results),
+	}
+	t := template.Must(template.New("risk").Parse(report))
+	err := t.Execute(w, data)
+	return err
+}
@@ -0,0 +1,63 @@
+package report
+
+import (
+	"io"
+	"text/template"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/routines"
+)
+
+// RoutinesReport is the structured version of the stored routines, views and triggers report
+type RoutinesReport struct {
+	Objects []RoutineResult `json:"objects" yaml:"objects"`
+}
+
+// RoutineResult holds the grants needed by a stored routine, view or trigger
+type RoutineResult struct {
+	Type          string        `json:"type" yaml:"type"`
+	Database      string        `json:"database" yaml:"database"`
+	Name          string        `json:"name" yaml:"name"`
+	Definer       string        `json:"definer" yaml:"definer"`
+	SQLSecurity   string        `json:"sql_security" yaml:"sql_security"`
+	InvokerGrants []GrantResult `json:"invoker_grants" yaml:"invoker_grants"`
+	DefinerGrants []GrantResult `json:"definer_grants" yaml:"definer_grants"`
+}
+
+// NewRoutinesReport builds the structured report from the analyzed objects
+func NewRoutinesReport(objects []*routines.Object) *RoutinesReport {
+	r := &RoutinesReport{Objects: []RoutineResult{}}
+	for _, o := range objects {
+		r.Objects = append(r.Objects, RoutineResult{
+			Type:          o.Kind,
+			Database:      o.Database,
+			Name:          o.Name,
+			Definer:       o.DefinerAccount().String(),
+			SQLSecurity:   o.SQLSecurity(),
+			InvokerGrants: grantResults(o.InvokerGrants),
+			DefinerGrants: grantResults(o.DefinerGrants),
+		})
+	}
+	return r
+}
+
+// PrintRoutinesReport prints, for each stored routine, view and trigger, the grants needed by the
+// accounts using it and the grants needed by its definer
+func PrintRoutinesReport(objects []*routines.Object, w io.Writer) error {
+	report := `### Stored routines, views and triggers ------------------------------------------------------------
+{{ range . }}
+{{ .String }} (definer {{ .DefinerAccount }}, SQL SECURITY {{ .SQLSecurity }})
+    Invoker grants:
+{{- range .InvokerGrants }}
+        {{ . }}
+{{- end }}
+    Definer grants:
+{{- range .DefinerGrants }}
+        {{ . }}
+{{- else }}
+        none
+{{- end }}
+{{ end -}}
+`
+	t := template.Must(template.New("routines").Parse(report))
+	return t.Execute(w, objects)
+}
@@ -0,0 +1,274 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+)
+
+type stringSet map[string]bool
+
+type tableKey struct {
+	database string
+	table    string
+}
+
+// MergeGrants merges the grants needed by all the testing cases into the minimal list of
+// grants covering all of them. Privileges already granted at a wider level are removed from
+// the narrower levels, e.g. SELECT ON `sakila`.`film` is not needed if SELECT ON `sakila`.*
+// is also required.
+func MergeGrants(results []*tester.TestingCase) []tester.Grant {
+	global := stringSet{}
+	schemas := map[string]stringSet{}
+	tables := map[tableKey]stringSet{}
+	// columns holds the list of columns for each privilege in each table
+	columns := map[tableKey]map[string]stringSet{}
+
+	for _, res := range results {
+		grants := res.ObjectGrants
+		if len(grants) == 0 {
+			grants = []tester.Grant{{Privileges: res.MinimumGrants}}
+		}
+		for _, grant := range grants {
+			key := tableKey{database: grant.Database, table: grant.Table}
+			for _, priv := range grant.Privileges {
+				if priv == "USAGE" {
+					continue
+				}
+				switch {
+				case grant.Database == "":
+					global[priv] = true
+				case grant.Table == "":
+					if schemas[grant.Database] == nil {
+						schemas[grant.Database] = stringSet{}
+					}
+					schemas[grant.Database][priv] = true
+				case len(grant.Columns) == 0:
+					if tables[key] == nil {
+						tables[key] = stringSet{}
+					}
+					tables[key][priv] = true
+				default:
+					if columns[key] == nil {
+						columns[key] = map[string]stringSet{}
+					}
+					if columns[key][priv] == nil {
+						columns[key][priv] = stringSet{}
+					}
+					for _, col := range grant.Columns {
+						columns[key][priv][col] = true
+					}
+				}
+			}
+		}
+	}
+
+	merged := []tester.Grant{}
+	if len(global) > 0 {
+		merged = append(merged, tester.Grant{Privileges: global.sorted()})
+	}
+
+	dbs := []string{}
+	for db := range schemas {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+	for _, db := range dbs {
+		if privs := schemas[db].without(global); len(privs) > 0 {
+			merged = append(merged, tester.Grant{Privileges: privs, Database: db})
+		}
+	}
+
+	tableKeys := []tableKey{}
+	for key := range tables {
+		tableKeys = append(tableKeys, key)
+	}
+	for _, key := range sortTableKeys(tableKeys) {
+		if privs := tables[key].without(global, schemas[key.database]); len(privs) > 0 {
+			merged = append(merged, tester.Grant{Privileges: privs, Database: key.database, Table: key.table})
+		}
+	}
+
+	tableKeys = []tableKey{}
+	for key := range columns {
+		tableKeys = append(tableKeys, key)
+	}
+	for _, key := range sortTableKeys(tableKeys) {
+		privs := stringSet{}
+		for priv := range columns[key] {
+			privs[priv] = true
+		}
+		// Privileges having the same columns list are granted together: SELECT (`a`), UPDATE (`a`)
+		byColumns := map[string]*tester.Grant{}
+		order := []string{}
+		for _, priv := range privs.without(global, schemas[key.database], tables[key]) {
+			cols := columns[key][priv].sorted()
+			colsKey := strings.Join(cols, ",")
+			if g, ok := byColumns[colsKey]; ok {
+				g.Privileges = append(g.Privileges, priv)
+				continue
+			}
+			byColumns[colsKey] = &tester.Grant{Privileges: []string{priv}, Database: key.database,
+				Table: key.table, Columns: cols}
+			order = append(order, colsKey)
+		}
+		for _, colsKey := range order {
+			merged = append(merged, *byColumns[colsKey])
+		}
+	}
+
+	return merged
+}
+
+// PrintGrantsScript prints a SQL script that creates the user and grants the merged minimum
+// grants needed to run all the queries. The grants use the first combination found for each
+// query, so the alternative combinations are listed in a comment.
+func PrintGrantsScript(results []*tester.TestingCase, user, host string, w io.Writer) error {
+	script := `{{ if .Alternatives -}}
+-- These queries can also run with the alternative grants, not used in this script:
+{{ range .Alternatives -}}
+--     {{ . }}
+{{ end -}}
+{{ end -}}
+CREATE USER IF NOT EXISTS {{ .Account }};
+{{ range .Grants -}}
+GRANT {{ . }} TO {{ $.Account }};
+{{ end -}}
+`
+	alternatives := []string{}
+	for _, res := range results {
+		if len(res.AlternativeGrants) > 0 {
+			alternatives = append(alternatives, stripCtlFromUTF8(res.Query)+": "+grantsKey(res))
+		}
+	}
+	data := struct {
+		Account      string
+		Grants       []tester.Grant
+		Alternatives []string
+	}{
+		Account:      fmt.Sprintf("%s@%s", quoteString(user), quoteString(host)),
+		Grants:       MergeGrants(results),
+		Alternatives: alternatives,
+	}
+	t := template.Must(template.New("script").Parse(script))
+	err := t.Execute(w, data)
+	return err
+}
+
+// Role holds the grants of a MySQL 8 role created for a group of queries needing the same grants
+type Role struct {
+	Name string
+	// Group is the grants group as shown in the report
+	Group  string
+	Grants []tester.Grant
+}
+
+// Roles returns a role for each group of queries, as grouped by GroupResults. Groups not
+// needing any privilege don't have a role. Roles are named prefix1, prefix2, etc.
+func Roles(results []*tester.TestingCase, prefix string) []Role {
+	groups := map[string][]*tester.TestingCase{}
+	keys := []string{}
+	for _, res := range results {
+		key := grantsKey(res)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], res)
+	}
+	sort.Strings(keys)
+
+	roles := []Role{}
+	for _, key := range keys {
+		grants := MergeGrants(groups[key])
+		if len(grants) == 0 {
+			continue
+		}
+		roles = append(roles, Role{
+			Name:   fmt.Sprintf("%s%d", prefix, len(roles)+1),
+			Group:  key,
+			Grants: grants,
+		})
+	}
+
+	return roles
+}
+
+// PrintRolesScript prints a SQL script that creates a MySQL 8 role for each group of queries
+// needing the same grants, creates the user and grants it all the roles as default roles.
+func PrintRolesScript(results []*tester.TestingCase, user, host, rolePrefix string, w io.Writer) error {
+	script := `{{ range $role := .Roles -}}
+-- {{ $role.Group }}
+CREATE ROLE IF NOT EXISTS {{ quote $role.Name }};
+{{ range $role.Grants -}}
+GRANT {{ . }} TO {{ quote $role.Name }};
+{{ end }}
+{{ end -}}
+CREATE USER IF NOT EXISTS {{ .Account }};
+{{ if .Roles -}}
+GRANT {{ .RolesList }} TO {{ .Account }};
+SET DEFAULT ROLE {{ .RolesList }} TO {{ .Account }};
+{{ end -}}
+`
+	roles := Roles(results, rolePrefix)
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, quoteString(role.Name))
+	}
+	data := struct {
+		Account   string
+		Roles     []Role
+		RolesList string
+	}{
+		Account:   fmt.Sprintf("%s@%s", quoteString(user), quoteString(host)),
+		Roles:     roles,
+		RolesList: strings.Join(names, ", "),
+	}
+	t := template.Must(template.New("roles").Funcs(template.FuncMap{"quote": quoteString}).Parse(script))
+	err := t.Execute(w, data)
+	return err
+}
+
+func quoteString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func (s stringSet) sorted() []string {
+	list := make([]string, 0, len(s))
+	for k := range s {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// without returns the sorted list of the set items not included in any of the exclude sets
+func (s stringSet) without(exclude ...stringSet) []string {
+	list := []string{}
+	for _, k := range s.sorted() {
+		excluded := false
+		for _, e := range exclude {
+			if e[k] {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			list = append(list, k)
+		}
+	}
+	return list
+}
+
+func sortTableKeys(keys []tableKey) []tableKey {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].database != keys[j].database {
+			return keys[i].database < keys[j].database
+		}
+		return keys[i].table < keys[j].table
+	})
+	return keys
+}
@@ -0,0 +1,117 @@
+package report
+
+import (
+	"io"
+	"text/template"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+)
+
+// VersionResults holds the testing results for a MySQL version
+type VersionResults struct {
+	Version        string
+	Results        []*tester.TestingCase
+	InvalidQueries []*tester.TestingCase
+}
+
+// VersionsReport is the structured version of the report comparing several MySQL versions,
+// used for the JSON and YAML output formats
+type VersionsReport struct {
+	Versions    []VersionReport `json:"versions" yaml:"versions"`
+	Differences []QueryDiff     `json:"differences" yaml:"differences"`
+}
+
+// VersionReport holds the report for a MySQL version
+type VersionReport struct {
+	Version string  `json:"version" yaml:"version"`
+	Report  *Report `json:"report" yaml:"report"`
+}
+
+// QueryDiff holds the grants needed by a query in each MySQL version
+type QueryDiff struct {
+	Query    string          `json:"query" yaml:"query"`
+	Versions []VersionGrants `json:"versions" yaml:"versions"`
+}
+
+// VersionGrants holds the grants needed by a query in a MySQL version
+type VersionGrants struct {
+	Version string `json:"version" yaml:"version"`
+	Grants  string `json:"grants" yaml:"grants"`
+}
+
+const (
+	invalidQueryGrants = "invalid query"
+	notFoundGrants     = "grants not found"
+)
+
+// Diff returns the queries whose minimum grants are not the same in all the versions
+func Diff(versions []VersionResults) []QueryDiff {
+	queries := []string{}
+	grants := map[string]map[string]string{}
+	add := func(version, query, g string) {
+		if _, ok := grants[query]; !ok {
+			grants[query] = map[string]string{}
+			queries = append(queries, query)
+		}
+		grants[query][version] = g
+	}
+
+	for _, v := range versions {
+		for _, tc := range v.Results {
+			add(v.Version, tc.Query, grantsKey(tc))
+		}
+		for _, tc := range v.InvalidQueries {
+			add(v.Version, tc.Query, invalidQueryGrants)
+		}
+	}
+
+	diffs := []QueryDiff{}
+	for _, query := range queries {
+		diff := QueryDiff{Query: query}
+		different := false
+		for _, v := range versions {
+			g, ok := grants[query][v.Version]
+			if !ok {
+				g = notFoundGrants
+			}
+			if len(diff.Versions) > 0 && diff.Versions[0].Grants != g {
+				different = true
+			}
+			diff.Versions = append(diff.Versions, VersionGrants{Version: v.Version, Grants: g})
+		}
+		if different {
+			diffs = append(diffs, diff)
+		}
+	}
+
+	return diffs
+}
+
+// NewVersionsReport builds the structured report comparing the MySQL versions
+func NewVersionsReport(versions []VersionResults) *VersionsReport {
+	r := &VersionsReport{Versions: []VersionReport{}, Differences: Diff(versions)}
+	for _, v := range versions {
+		r.Versions = append(r.Versions, VersionReport{
+			Version: v.Version,
+			Report:  NewReport(v.Results, v.InvalidQueries),
+		})
+	}
+	return r
+}
+
+// PrintVersionsDiff prints the queries whose minimum grants are different between versions
+func PrintVersionsDiff(versions []VersionResults, w io.Writer) error {
+	report := `### Grants differences between versions ------------------------------------------------------------
+{{ range . }}
+Query: {{ .Query }}
+{{- range .Versions }}
+    {{ .Version }} : {{ .Grants }}
+{{- end }}
+{{ else }}
+The minimum grants are the same in all versions
+{{ end -}}
+`
+	t := template.Must(template.New("diff").Parse(report))
+	err := t.Execute(w, Diff(versions))
+	return err
+}
@@ -0,0 +1,109 @@
+// Package routines computes the grants needed to use the stored routines, views and triggers
+// and, separately, the grants their definers need to run their bodies.
+package routines
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/qparser"
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+)
+
+// Object holds the grants needed by a stored routine, view or trigger
+type Object struct {
+	*qparser.Definition
+	// InvokerGrants are the grants needed by the accounts calling the routine, selecting from
+	// the view or firing the trigger
+	InvokerGrants []tester.Grant
+	// DefinerGrants are the grants the definer account needs to run the body. They are empty for
+	// the SQL SECURITY INVOKER routines and views, since their body runs with the invoker grants.
+	DefinerGrants []tester.Grant
+}
+
+// String returns the object type and name: PROCEDURE `db`.`name`
+func (o *Object) String() string {
+	return fmt.Sprintf("%s %s.%s", o.Kind, quoteIdent(o.Database), quoteIdent(o.Name))
+}
+
+// DefinerAccount returns the definer account
+func (o *Object) DefinerAccount() tester.Account {
+	return tester.Account{User: o.DefinerUser, Host: o.DefinerHost}
+}
+
+// SQLSecurity returns the security context the body runs with: DEFINER or INVOKER. Triggers
+// always run with the definer grants.
+func (o *Object) SQLSecurity() string {
+	if o.Invoker && o.Kind != "TRIGGER" {
+		return "INVOKER"
+	}
+	return "DEFINER"
+}
+
+// Definitions returns the routines, views and triggers created by the schema statements. The USE
+// statements set the database for the unqualified names.
+func Definitions(statements []string) []*qparser.Definition {
+	definitions := []*qparser.Definition{}
+	database := ""
+	for _, stmt := range statements {
+		if db, ok := qparser.UseDatabase(stmt); ok {
+			database = db
+			continue
+		}
+		if def, ok := qparser.ParseDefinition(stmt, database); ok {
+			definitions = append(definitions, def)
+		}
+	}
+	return definitions
+}
+
+// TestCases returns a testing case for each statement in the body, to search the grants needed
+// to run it. The statements having placeholders are run as prepared statements.
+func TestCases(def *qparser.Definition) []*tester.TestingCase {
+	tc := []*tester.TestingCase{}
+	for _, stmt := range def.Statements {
+		tc = append(tc, &tester.TestingCase{
+			Database: def.Database,
+			Query:    stmt,
+			Prepared: qparser.HasPlaceholders(stmt),
+		})
+	}
+	return tc
+}
+
+// InvokerGrants returns the grants needed to use the object, without the grants needed by the
+// body: EXECUTE on the routines, SELECT on the views and, for the triggers, the privilege of the
+// event firing it on its table.
+func InvokerGrants(def *qparser.Definition) []tester.Grant {
+	switch def.Kind {
+	case "PROCEDURE", "FUNCTION":
+		return []tester.Grant{{Privileges: []string{"EXECUTE"}, Database: def.Database, Table: def.Name,
+			Routine: def.Kind}}
+	case "VIEW":
+		return []tester.Grant{{Privileges: []string{"SELECT"}, Database: def.Database, Table: def.Name}}
+	case "TRIGGER":
+		return []tester.Grant{{Privileges: []string{def.Event}, Database: def.Database, Table: def.Table}}
+	}
+	return nil
+}
+
+// Analyze returns the invoker and definer grants for the object, given the grants needed to run
+// its body. The body of the triggers and of the SQL SECURITY DEFINER routines and views runs
+// with the definer grants, and the trigger definer also needs the TRIGGER privilege on the table.
+func Analyze(def *qparser.Definition, body []tester.Grant) *Object {
+	o := &Object{Definition: def, InvokerGrants: InvokerGrants(def), DefinerGrants: []tester.Grant{}}
+	if o.SQLSecurity() == "INVOKER" {
+		o.InvokerGrants = append(o.InvokerGrants, body...)
+		return o
+	}
+	if def.Kind == "TRIGGER" {
+		o.DefinerGrants = append(o.DefinerGrants, tester.Grant{Privileges: []string{"TRIGGER"},
+			Database: def.Database, Table: def.Table})
+	}
+	o.DefinerGrants = append(o.DefinerGrants, body...)
+	return o
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
@@ -0,0 +1,63 @@
+package routines
+
+import (
+	"testing"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
+	tu "github.com/Percona-Lab/minimum_permissions/internal/testutils"
+)
+
+func TestDefinitions(t *testing.T) {
+	statements := []string{
+		"CREATE DATABASE shop",
+		"USE shop",
+		"CREATE TABLE orders (id INT, total INT)",
+		"CREATE DEFINER=`app`@`%` PROCEDURE close_order(o INT) UPDATE orders SET total = 0 WHERE id = o",
+		"USE `sakila`",
+		"CREATE VIEW v AS SELECT id FROM shop.orders",
+	}
+	defs := Definitions(statements)
+	tu.Equals(t, len(defs), 2)
+	tu.Equals(t, defs[0].Database, "shop")
+	tu.Equals(t, defs[0].Name, "close_order")
+	tu.Equals(t, defs[1].Database, "sakila")
+
+	tc := TestCases(defs[0])
+	tu.Equals(t, tc, []*tester.TestingCase{
+		{Database: "shop", Query: "UPDATE orders SET total = 0 WHERE id = ?", Prepared: true},
+	})
+	tu.Equals(t, TestCases(defs[1])[0].Prepared, false)
+}
+
+func TestAnalyze(t *testing.T) {
+	body := []tester.Grant{{Privileges: []string{"UPDATE"}, Database: "shop", Table: "orders"}}
+	defs := Definitions([]string{
+		"USE shop",
+		"CREATE DEFINER=`app`@`%` PROCEDURE close_order(o INT) UPDATE orders SET total = 0 WHERE id = o",
+		"CREATE DEFINER=`app`@`%` SQL SECURITY INVOKER VIEW v AS SELECT id FROM orders",
+		"CREATE DEFINER=`app`@`%` TRIGGER upd BEFORE DELETE ON items FOR EACH ROW UPDATE orders SET total = 0",
+	})
+
+	o := Analyze(defs[0], body)
+	tu.Equals(t, o.String(), "PROCEDURE `shop`.`close_order`")
+	tu.Equals(t, o.DefinerAccount(), tester.Account{User: "app", Host: "%"})
+	tu.Equals(t, o.InvokerGrants, []tester.Grant{
+		{Privileges: []string{"EXECUTE"}, Database: "shop", Table: "close_order", Routine: "PROCEDURE"},
+	})
+	tu.Equals(t, o.DefinerGrants, body)
+
+	o = Analyze(defs[1], []tester.Grant{{Privileges: []string{"SELECT"}, Database: "shop", Table: "orders"}})
+	tu.Equals(t, o.InvokerGrants, []tester.Grant{
+		{Privileges: []string{"SELECT"}, Database: "shop", Table: "v"},
+		{Privileges: []string{"SELECT"}, Database: "shop", Table: "orders"},
+	})
+	tu.Equals(t, o.DefinerGrants, []tester.Grant{})
+	tu.Equals(t, o.SQLSecurity(), "INVOKER")
+
+	o = Analyze(defs[2], body)
+	tu.Equals(t, o.InvokerGrants, []tester.Grant{{Privileges: []string{"DELETE"}, Database: "shop", Table: "items"}})
+	tu.Equals(t, o.DefinerGrants, []tester.Grant{
+		{Privileges: []string{"TRIGGER"}, Database: "shop", Table: "items"},
+		{Privileges: []string{"UPDATE"}, Database: "shop", Table: "orders"},
+	})
+}
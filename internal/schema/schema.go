@@ -0,0 +1,444 @@
+package schema
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/qparser"
+)
+
+var delimiterRe = regexp.MustCompile(`(?i)^\s*DELIMITER\s+(\S+)\s*$`)
+
+// Split splits a SQL script, like the output of mysqldump --no-data, into statements.
+// It handles quoted strings, comments and the DELIMITER command used by the mysql client
+// for stored routines and triggers. Versioned comments (/*!40101 ... */) are kept since
+// the server executes them.
+func Split(r io.Reader) ([]string, error) {
+	statements := []string{}
+	delimiter := ";"
+	stmt := &strings.Builder{}
+	// quote is the quote character of the string being read. 0 means not in a string
+	var quote byte
+	inComment := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if quote == 0 && !inComment && strings.TrimSpace(stmt.String()) == "" {
+			if m := delimiterRe.FindStringSubmatch(line); m != nil {
+				delimiter = m[1]
+				stmt.Reset()
+				continue
+			}
+		}
+
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case inComment:
+				if strings.HasPrefix(line[i:], "*/") {
+					inComment = false
+					i++
+				}
+				continue
+			case quote != 0:
+				stmt.WriteByte(c)
+				if c == '\\' && quote != '`' && i+1 < len(line) {
+					i++
+					stmt.WriteByte(line[i])
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			case c == '\'' || c == '"' || c == '`':
+				quote = c
+			case strings.HasPrefix(line[i:], "/*") && !strings.HasPrefix(line[i:], "/*!"):
+				inComment = true
+				i++
+				continue
+			case strings.HasPrefix(line[i:], "-- ") || line[i:] == "--" || c == '#':
+				i = len(line)
+				continue
+			case strings.HasPrefix(line[i:], delimiter):
+				if s := strings.TrimSpace(stmt.String()); s != "" {
+					statements = append(statements, s)
+				}
+				stmt.Reset()
+				i += len(delimiter) - 1
+				continue
+			}
+			stmt.WriteByte(c)
+		}
+		stmt.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if s := strings.TrimSpace(stmt.String()); s != "" {
+		statements = append(statements, s)
+	}
+
+	return statements, nil
+}
+
+// Load executes the statements in a single connection, with foreign keys checks disabled so
+// tables can be created in any order.
+func Load(db *sql.DB, statements []string) error {
+	return load(db, statements, false)
+}
+
+// Restore executes again the statements that created a schema, to recreate the objects dropped
+// since then. The errors about objects that already exist are ignored.
+func Restore(db *sql.DB, statements []string) error {
+	return load(db, statements, true)
+}
+
+// existsErrors are the errors returned when creating an object that already exists
+var existsErrors = map[uint16]bool{
+	1007: true, // Can't create database; database exists
+	1050: true, // Table already exists
+	1304: true, // PROCEDURE/FUNCTION already exists
+	1359: true, // Trigger already exists
+	1537: true, // Event already exists
+}
+
+func load(db *sql.DB, statements []string, ignoreExisting bool) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "cannot get a connection to load the schema")
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS=0"); err != nil {
+		return errors.Wrap(err, "cannot disable foreign keys checks")
+	}
+	defer conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS=1") // nolint
+
+	for _, stmt := range statements {
+		log.Debug().Msgf("Loading schema: %s", stmt)
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			if me, ok := err.(*mysql.MySQLError); ok && ignoreExisting && existsErrors[me.Number] {
+				continue
+			}
+			return errors.Wrapf(err, "cannot execute %q", stmt)
+		}
+	}
+
+	return nil
+}
+
+// SystemSchemas are the schemas that are not copied from a source server
+var SystemSchemas = map[string]bool{
+	"information_schema": true,
+	"mysql":              true,
+	"performance_schema": true,
+	"sys":                true,
+}
+
+// Dump returns the statements to create the schemas in a source server, including tables,
+// views, stored routines and triggers, without data. If the schemas list is empty, all the
+// schemas except the system ones are dumped.
+func Dump(db *sql.DB, schemas []string) ([]string, error) {
+	var err error
+	if len(schemas) == 0 {
+		if schemas, err = ListSchemas(db); err != nil {
+			return nil, err
+		}
+	}
+
+	statements := []string{}
+	// Views are created after all the tables since they can use tables in other schemas
+	views := []view{}
+	for _, schema := range schemas {
+		var name, create string
+		// Some schemas, like test, might already exist in the sandbox
+		err := db.QueryRow(fmt.Sprintf("SHOW CREATE DATABASE IF NOT EXISTS %s", quoteIdent(schema))).Scan(&name, &create)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get the CREATE DATABASE statement for %q", schema)
+		}
+		statements = append(statements, create)
+
+		tables, err := listTables(db, schema)
+		if err != nil {
+			return nil, err
+		}
+		for _, table := range tables {
+			create, isView, err := showCreate(db, "TABLE", schema, table)
+			if err != nil {
+				return nil, err
+			}
+			if isView {
+				views = append(views, view{schema: schema, name: table, create: create})
+				continue
+			}
+			statements = append(statements, "USE "+quoteIdent(schema), create)
+		}
+	}
+	statements = append(statements, sortViews(views)...)
+
+	for _, schema := range schemas {
+		objects, err := listRoutinesAndTriggers(db, schema)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objects {
+			create, _, err := showCreate(db, obj.kind, schema, obj.name)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, "USE "+quoteIdent(schema), create)
+		}
+	}
+
+	return statements, nil
+}
+
+// view is a view read from the source server
+type view struct {
+	schema string
+	name   string
+	create string
+}
+
+// sortViews returns the statements to create the views, creating the views used by other views
+// first. Views in a dependency cycle, that cannot be created anyway, keep their order.
+func sortViews(views []view) []string {
+	key := func(schema, name string) string {
+		return schema + "." + name
+	}
+	pending := map[string]bool{}
+	for _, v := range views {
+		pending[key(v.schema, v.name)] = true
+	}
+
+	deps := make([][]string, len(views))
+	for i, v := range views {
+		def, ok := qparser.ParseDefinition(v.create, v.schema)
+		if !ok {
+			continue
+		}
+		for _, stmt := range def.Statements {
+			for _, t := range qparser.Parse(stmt, v.schema).Tables {
+				if k := key(t.Database, t.Name); pending[k] && k != key(v.schema, v.name) {
+					deps[i] = append(deps[i], k)
+				}
+			}
+		}
+	}
+
+	statements := []string{}
+	done := make([]bool, len(views))
+	for added := true; added; {
+		added = false
+		for i, v := range views {
+			if done[i] || !ready(deps[i], pending) {
+				continue
+			}
+			statements = append(statements, "USE "+quoteIdent(v.schema), v.create)
+			delete(pending, key(v.schema, v.name))
+			done[i], added = true, true
+		}
+	}
+	for i, v := range views {
+		if !done[i] {
+			statements = append(statements, "USE "+quoteIdent(v.schema), v.create)
+		}
+	}
+
+	return statements
+}
+
+// ready returns true if none of the dependencies is pending
+func ready(deps []string, pending map[string]bool) bool {
+	for _, dep := range deps {
+		if pending[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// ListSchemas returns the schemas in the server, except the system ones
+func ListSchemas(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SHOW DATABASES")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get the databases list")
+	}
+	defer rows.Close()
+
+	schemas := []string{}
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return nil, errors.Wrap(err, "cannot get the databases list")
+		}
+		if !SystemSchemas[strings.ToLower(schema)] {
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas, rows.Err()
+}
+
+func listTables(db *sql.DB, schema string) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("SHOW FULL TABLES FROM %s", quoteIdent(schema)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get the tables list for %q", schema)
+	}
+	defer rows.Close()
+
+	tables := []string{}
+	for rows.Next() {
+		var table, tableType string
+		if err := rows.Scan(&table, &tableType); err != nil {
+			return nil, errors.Wrapf(err, "cannot get the tables list for %q", schema)
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+type schemaObject struct {
+	kind string
+	name string
+}
+
+func listRoutinesAndTriggers(db *sql.DB, schema string) ([]schemaObject, error) {
+	query := `SELECT ROUTINE_TYPE, ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = ?
+               UNION ALL
+              SELECT 'TRIGGER', TRIGGER_NAME FROM information_schema.TRIGGERS WHERE TRIGGER_SCHEMA = ?`
+	rows, err := db.Query(query, schema, schema)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get the routines and triggers list for %q", schema)
+	}
+	defer rows.Close()
+
+	objects := []schemaObject{}
+	for rows.Next() {
+		var obj schemaObject
+		if err := rows.Scan(&obj.kind, &obj.name); err != nil {
+			return nil, errors.Wrapf(err, "cannot get the routines and triggers list for %q", schema)
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// showCreate returns the CREATE statement for an object. SHOW CREATE returns a different number
+// of columns depending on the object type and the CREATE statement is in a different column, so
+// the column is located by its name. isView is true if SHOW CREATE TABLE returned a view.
+func showCreate(db *sql.DB, kind, schema, name string) (string, bool, error) {
+	rows, err := db.Query(fmt.Sprintf("SHOW CREATE %s %s.%s", kind, quoteIdent(schema), quoteIdent(name)))
+	if err != nil {
+		return "", false, errors.Wrapf(err, "cannot get the CREATE statement for %s %s.%s", kind, schema, name)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", false, err
+	}
+	if !rows.Next() {
+		return "", false, fmt.Errorf("cannot get the CREATE statement for %s %s.%s", kind, schema, name)
+	}
+	values := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return "", false, err
+	}
+	for i, col := range cols {
+		if strings.HasPrefix(col, "Create ") || strings.HasPrefix(col, "SQL Original Statement") {
+			return values[i].String, col == "Create View", nil
+		}
+	}
+
+	return "", false, fmt.Errorf("cannot find the CREATE statement for %s %s.%s", kind, schema, name)
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// Stubs returns the statements to create stub schemas and tables for the objects referenced by
+// the queries, so the queries run far enough to hit the privilege checks instead of failing with
+// "table doesn't exist". All the columns used by the queries are created as TEXT. Tables having
+// no known columns get a single stub_id column. Existing schemas and tables, and the system
+// schemas, are not modified.
+func Stubs(objects []*qparser.Objects) []string {
+	databases := []string{}
+	tables := map[string]map[string][]string{}
+	tableNames := map[string][]string{}
+
+	for _, o := range objects {
+		for _, t := range o.Tables {
+			if t.Database == "" || t.Name == "" || SystemSchemas[strings.ToLower(t.Database)] {
+				continue
+			}
+			if _, ok := tables[t.Database]; !ok {
+				tables[t.Database] = map[string][]string{}
+				databases = append(databases, t.Database)
+			}
+			cols, ok := tables[t.Database][t.Name]
+			if !ok {
+				tableNames[t.Database] = append(tableNames[t.Database], t.Name)
+			}
+			for _, col := range t.Columns {
+				if !containsFold(cols, col) {
+					cols = append(cols, col)
+				}
+			}
+			tables[t.Database][t.Name] = cols
+		}
+		for _, db := range o.Databases {
+			if _, ok := tables[db]; !ok && db != "" && !SystemSchemas[strings.ToLower(db)] {
+				tables[db] = map[string][]string{}
+				databases = append(databases, db)
+			}
+		}
+	}
+
+	sort.Strings(databases)
+	statements := []string{}
+	for _, db := range databases {
+		statements = append(statements, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", quoteIdent(db)))
+		names := tableNames[db]
+		sort.Strings(names)
+		for _, name := range names {
+			cols := tables[db][name]
+			if len(cols) == 0 {
+				cols = []string{"stub_id"}
+			}
+			defs := make([]string, 0, len(cols))
+			for _, col := range cols {
+				defs = append(defs, quoteIdent(col)+" TEXT")
+			}
+			statements = append(statements, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s (%s)",
+				quoteIdent(db), quoteIdent(name), strings.Join(defs, ", ")))
+		}
+	}
+
+	return statements
+}
+
+func containsFold(list []string, item string) bool {
+	for _, i := range list {
+		if strings.EqualFold(i, item) {
+			return true
+		}
+	}
+	return false
+}
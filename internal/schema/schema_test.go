@@ -0,0 +1,81 @@
+package schema
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/qparser"
+	tu "github.com/Percona-Lab/minimum_permissions/internal/testutils"
+)
+
+func TestSplit(t *testing.T) {
+	fh, err := os.Open("testdata/dump.sql")
+	tu.IsNil(t, err)
+	defer fh.Close()
+
+	want := []string{
+		"/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */",
+		"/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */",
+		"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `sakila` /*!40100 DEFAULT CHARACTER SET latin1 */",
+		"USE `sakila`",
+		"DROP TABLE IF EXISTS `actor`",
+		"/*!40101 SET @saved_cs_client     = @@character_set_client */",
+		"CREATE TABLE `actor` (\n" +
+			"  `actor_id` smallint(5) unsigned NOT NULL AUTO_INCREMENT,\n" +
+			"  `first_name` varchar(45) NOT NULL COMMENT 'first name; given name',\n" +
+			"  `last_name` varchar(45) NOT NULL DEFAULT 'it''s -- not a comment',\n" +
+			"  PRIMARY KEY (`actor_id`)\n" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8",
+		"CREATE DEFINER=`root`@`localhost` PROCEDURE `rewards_report`(IN min_purchases INT)\n" +
+			"BEGIN\n" +
+			"  SELECT COUNT(*) FROM actor; \n" +
+			"  SELECT \"done;\";\n" +
+			"END",
+		"/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */",
+	}
+
+	statements, err := Split(fh)
+	tu.IsNil(t, err)
+	tu.Equals(t, statements, want)
+}
+
+func TestSortViews(t *testing.T) {
+	views := []view{
+		{schema: "shop", name: "a_totals", create: "CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`localhost` " +
+			"SQL SECURITY DEFINER VIEW `a_totals` AS select sum(`b_orders`.`total`) AS `t` from `b_orders`"},
+		{schema: "shop", name: "b_orders", create: "CREATE VIEW `b_orders` AS select * from `reports`.`c_base`"},
+		{schema: "reports", name: "c_base", create: "CREATE VIEW `c_base` AS select * from `shop`.`orders`"},
+		{schema: "shop", name: "loop1", create: "CREATE VIEW `loop1` AS select * from `loop2`"},
+		{schema: "shop", name: "loop2", create: "CREATE VIEW `loop2` AS select * from `loop1`"},
+	}
+	want := []string{
+		"USE `reports`", views[2].create,
+		"USE `shop`", views[1].create,
+		"USE `shop`", views[0].create,
+		"USE `shop`", views[3].create,
+		"USE `shop`", views[4].create,
+	}
+	tu.Equals(t, sortViews(views), want)
+}
+
+func TestStubs(t *testing.T) {
+	objects := []*qparser.Objects{
+		qparser.Parse("SELECT title, release_year FROM sakila.film WHERE film_id = 1", "test"),
+		qparser.Parse("UPDATE sakila.film SET Title = 'x', length = 10", "test"),
+		qparser.Parse("SELECT * FROM t1 JOIN world.city c ON t1.id = c.id", "test"),
+		qparser.Parse("CREATE DATABASE newdb", "test"),
+		qparser.Parse("SELECT a FROM t2", ""),
+		qparser.Parse("SELECT table_name FROM information_schema.tables", "test"),
+	}
+	want := []string{
+		"CREATE DATABASE IF NOT EXISTS `newdb`",
+		"CREATE DATABASE IF NOT EXISTS `sakila`",
+		"CREATE TABLE IF NOT EXISTS `sakila`.`film` (`title` TEXT, `release_year` TEXT, `film_id` TEXT, `length` TEXT)",
+		"CREATE DATABASE IF NOT EXISTS `test`",
+		"CREATE TABLE IF NOT EXISTS `test`.`t1` (`stub_id` TEXT)",
+		"CREATE DATABASE IF NOT EXISTS `world`",
+		"CREATE TABLE IF NOT EXISTS `world`.`city` (`stub_id` TEXT)",
+	}
+
+	tu.Equals(t, want, Stubs(objects))
+}
@@ -0,0 +1,34 @@
+-- MySQL dump 10.13  Distrib 8.0.13, for linux-glibc2.12 (x86_64)
+--
+-- Host: 127.0.0.1    Database: sakila
+-- ------------------------------------------------------
+
+/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;
+
+CREATE DATABASE /*!32312 IF NOT EXISTS*/ `sakila` /*!40100 DEFAULT CHARACTER SET latin1 */;
+
+USE `sakila`;
+
+--
+-- Table structure for table `actor`
+--
+
+DROP TABLE IF EXISTS `actor`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+CREATE TABLE `actor` (
+  `actor_id` smallint(5) unsigned NOT NULL AUTO_INCREMENT,
+  `first_name` varchar(45) NOT NULL COMMENT 'first name; given name',
+  `last_name` varchar(45) NOT NULL DEFAULT 'it''s -- not a comment',
+  PRIMARY KEY (`actor_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/* a multi line
+   comment; with a delimiter */
+DELIMITER ;;
+CREATE DEFINER=`root`@`localhost` PROCEDURE `rewards_report`(IN min_purchases INT)
+BEGIN
+  SELECT COUNT(*) FROM actor; # count actors
+  SELECT "done;";
+END ;;
+DELIMITER ;
+/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
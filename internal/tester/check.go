@@ -0,0 +1,297 @@
+package tester
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// GRANT <privileges> ON [object_type] <level> TO <account> [WITH GRANT OPTION]
+	grantStmtRe = regexp.MustCompile(`(?is)^GRANT\s+(.+?)\s+ON\s+(?:(TABLE|FUNCTION|PROCEDURE)\s+)?(\S+)\s+TO\s+(.+?)$`)
+	onRe        = regexp.MustCompile(`(?i)\sON\s`)
+	proxyRe     = regexp.MustCompile(`(?i)^GRANT\s+PROXY\s`)
+	// `db`.`table`, db.*, *.*
+	grantLevelRe = regexp.MustCompile("^(`(?:[^`]|``)+`|[^.`]+)\\.(`(?:[^`]|``)+`|[^.`]+)$")
+)
+
+// ParseGrants reads GRANT statements, like the output of SHOW GRANTS, and returns the grants.
+// Lines not starting with GRANT, like the "Grants for user@host" header, are ignored and the
+// mysql client table borders are removed. Roles grants (GRANT role TO user) and PROXY grants
+// are not supported and they are skipped.
+func ParseGrants(r io.Reader) ([]Grant, error) {
+	grants := []Grant{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		line = strings.TrimSpace(strings.Trim(line, "|"))
+		line = strings.TrimSpace(strings.TrimSuffix(line, ";"))
+		if !strings.HasPrefix(strings.ToUpper(line), "GRANT ") {
+			continue
+		}
+		// Roles grants have no privilege level and PROXY grants have an account as the level
+		if !onRe.MatchString(line) || proxyRe.MatchString(line) {
+			log.Warn().Msgf("Skipping unsupported grant: %s", line)
+			continue
+		}
+		g, err := ParseGrant(line)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, g...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// ParseGrant parses a GRANT statement. Since all the privileges of a Grant must have the same
+// columns list, the statement is returned as several grants if it has column privileges:
+// GRANT SELECT (a), INSERT ON db.t returns SELECT (a) ON db.t and INSERT ON db.t
+func ParseGrant(stmt string) ([]Grant, error) {
+	m := grantStmtRe.FindStringSubmatch(strings.TrimSpace(stmt))
+	if m == nil {
+		return nil, fmt.Errorf("cannot parse the grant %q", stmt)
+	}
+
+	lm := grantLevelRe.FindStringSubmatch(m[3])
+	if lm == nil {
+		return nil, fmt.Errorf("cannot parse the privilege level in %q", stmt)
+	}
+	base := Grant{}
+	if lm[1] != "*" {
+		base.Database = unquoteIdent(lm[1])
+		if lm[2] != "*" {
+			base.Table = unquoteIdent(lm[2])
+		}
+	}
+	if routine := strings.ToUpper(m[2]); routine == "FUNCTION" || routine == "PROCEDURE" {
+		base.Routine = routine
+	}
+
+	privileges := splitPrivilegesList(m[1])
+	if strings.HasSuffix(strings.ToUpper(m[4]), "WITH GRANT OPTION") {
+		privileges = append(privileges, "GRANT OPTION")
+	}
+
+	grants := []Grant{}
+	tablePrivs := []string{}
+	for _, priv := range privileges {
+		name, columns := priv, []string(nil)
+		if i := strings.Index(priv, "("); i > 0 {
+			name = strings.TrimSpace(priv[:i])
+			for _, col := range strings.Split(strings.Trim(priv[i:], "()"), ",") {
+				columns = append(columns, unquoteIdent(strings.TrimSpace(col)))
+			}
+		}
+		name = strings.ToUpper(name)
+		if name == "ALL" {
+			name = "ALL PRIVILEGES"
+		}
+		if len(columns) == 0 {
+			tablePrivs = append(tablePrivs, name)
+			continue
+		}
+		g := base
+		g.Privileges = []string{name}
+		g.Columns = columns
+		grants = append(grants, g)
+	}
+	if len(tablePrivs) > 0 {
+		g := base
+		g.Privileges = tablePrivs
+		grants = append([]Grant{g}, grants...)
+	}
+
+	return grants, nil
+}
+
+// splitPrivilegesList splits a privileges list like SELECT (`a`, `b`), INSERT at the commas
+// that are not inside a columns list
+func splitPrivilegesList(list string) []string {
+	privileges := []string{}
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				privileges = append(privileges, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(privileges, strings.TrimSpace(list[start:]))
+}
+
+func unquoteIdent(name string) string {
+	if len(name) >= 2 && name[0] == '`' && name[len(name)-1] == '`' {
+		return strings.Replace(name[1:len(name)-1], "``", "`", -1)
+	}
+	return name
+}
+
+// CheckResult holds the results of testing a workload with a fixed set of grants
+type CheckResult struct {
+	// Passed are the queries that can be executed with the grants
+	Passed []*TestingCase
+	// Failed are the queries that would fail because of missing privileges
+	Failed []*TestingCase
+	// Invalid are the queries with syntax errors
+	Invalid []*TestingCase
+	// Errors are the queries that could not be tested because of driver errors, like a lost
+	// connection, so it is unknown if they can run with the grants
+	Errors []*TestingCase
+	// Untested are the queries not tested because the check was interrupted
+	Untested []*TestingCase
+	// Interrupted is true if the check was stopped. The unused privileges are not checked then.
+	Interrupted bool
+	// Unused are the privileges that can be removed without breaking any of the passed queries.
+	// Each grant has a single privilege. Privileges are checked one at a time, so removing two
+	// unused privileges might break queries that can use any of them.
+	Unused []Grant
+}
+
+// CheckGrants runs all the testing cases with exactly the given grants and reports the queries
+// that would fail and the privileges not needed by any query.
+func CheckGrants(conn *sql.DB, dsnTemplate, user string, grants []Grant, testCases []*TestingCase,
+	stopChan chan bool) (*CheckResult, error) {
+	res := &CheckResult{}
+
+	tested, err := runWithGrants(conn, dsnTemplate, user, grants, testCases, stopChan)
+	if err != nil {
+		return nil, err
+	}
+	res.Interrupted = stopped(stopChan)
+	for i, tc := range testCases {
+		tc.Error = tested[i].Error
+		tc.NotAllowed = tested[i].NotAllowed
+		switch {
+		// The queries after the interruption were not run, so they don't have results
+		case res.Interrupted && tested[i].Error == nil && tested[i].MinimumGrants == nil:
+			res.Untested = append(res.Untested, tc)
+		case tested[i].InvalidQuery:
+			tc.InvalidQuery = true
+			res.Invalid = append(res.Invalid, tc)
+		case tested[i].NotAllowed:
+			res.Failed = append(res.Failed, tc)
+		case driverError(tested[i].Error):
+			res.Errors = append(res.Errors, tc)
+		default:
+			tc.ObjectGrants = grants
+			res.Passed = append(res.Passed, tc)
+		}
+	}
+	if res.Interrupted {
+		return res, nil
+	}
+
+	var unused []Grant
+	for i, grant := range grants {
+		for _, priv := range grant.Privileges {
+			if priv == "USAGE" {
+				continue
+			}
+			reduced := withoutPrivilege(grants, i, priv)
+			tested, err := runWithGrants(conn, dsnTemplate, user, reduced, res.Passed, stopChan)
+			if err != nil {
+				log.Debug().Msgf("Cannot test the grants without %s ON %s: %s", priv, grant.On(), err)
+				continue
+			}
+			// Some queries were not run, so the privilege might be used by them
+			if stopped(stopChan) {
+				res.Interrupted = true
+				return res, nil
+			}
+			if allowed(tested) {
+				g := grant
+				g.Privileges = []string{priv}
+				unused = append(unused, g)
+			}
+		}
+	}
+	res.Unused = unused
+
+	return res, nil
+}
+
+// runWithGrants runs copies of the testing cases using a test user having the grants
+func runWithGrants(conn *sql.DB, dsnTemplate, user string, grants []Grant, testCases []*TestingCase,
+	stopChan chan bool) ([]*TestingCase, error) {
+	testConn, err := NewTestConnectionWithGrants(conn, dsnTemplate, user, grants)
+	if err != nil {
+		return nil, err
+	}
+	defer testConn.Destroy()
+
+	copies := make([]*TestingCase, 0, len(testCases))
+	for _, tc := range testCases {
+		copies = append(copies, tc.Probe())
+	}
+	testConn.TestQueries(copies, stopChan)
+
+	return copies, nil
+}
+
+// allowed returns true if all the testing cases ran without access denied or driver errors
+func allowed(testCases []*TestingCase) bool {
+	for _, tc := range testCases {
+		if tc.NotAllowed || driverError(tc.Error) {
+			return false
+		}
+	}
+	return true
+}
+
+// driverError returns true if the error is not a MySQL server error, so the query was not run
+func driverError(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ok := err.(*mysql.MySQLError)
+	return !ok
+}
+
+// stopped returns true if the stop channel was closed
+func stopped(stopChan chan bool) bool {
+	select {
+	case <-stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// withoutPrivilege returns a copy of the grants list without the privilege in the i-th grant
+func withoutPrivilege(grants []Grant, i int, priv string) []Grant {
+	reduced := []Grant{}
+	for j, grant := range grants {
+		if j == i {
+			privileges := []string{}
+			for _, p := range grant.Privileges {
+				if p != priv {
+					privileges = append(privileges, p)
+				}
+			}
+			if len(privileges) == 0 {
+				continue
+			}
+			grant.Privileges = privileges
+		}
+		reduced = append(reduced, grant)
+	}
+	if len(reduced) == 0 {
+		reduced = []Grant{{Privileges: []string{"USAGE"}}}
+	}
+	return reduced
+}
@@ -0,0 +1,225 @@
+package tester
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rs/zerolog/log"
+)
+
+// Grant is a list of privileges granted at a specific level.
+// An empty Database means global level (*.*), an empty Table means schema level (db.*)
+// and a non empty Columns list means column level. Routine level grants have the routine name
+// in Table and PROCEDURE or FUNCTION in Routine.
+type Grant struct {
+	Privileges []string
+	Database   string
+	Table      string
+	Columns    []string
+	Routine    string
+}
+
+// Privileges allowed at each level.
+// https://dev.mysql.com/doc/refman/8.0/en/grant.html#grant-privileges
+// https://mariadb.com/kb/en/grant/#privilege-levels
+var (
+	schemaPrivileges = map[string]bool{
+		"ALTER": true, "ALTER ROUTINE": true, "CREATE": true, "CREATE ROUTINE": true,
+		"CREATE TEMPORARY TABLES": true, "CREATE VIEW": true, "DELETE": true, "DROP": true,
+		"EVENT": true, "EXECUTE": true, "GRANT OPTION": true, "INDEX": true, "INSERT": true,
+		"LOCK TABLES": true, "REFERENCES": true, "SELECT": true, "SHOW VIEW": true,
+		"TRIGGER": true, "UPDATE": true, "DELETE HISTORY": true,
+	}
+	tablePrivileges = map[string]bool{
+		"ALTER": true, "CREATE": true, "CREATE VIEW": true, "DELETE": true, "DELETE HISTORY": true, "DROP": true,
+		"GRANT OPTION": true, "INDEX": true, "INSERT": true, "REFERENCES": true, "SELECT": true,
+		"SHOW VIEW": true, "TRIGGER": true, "UPDATE": true,
+	}
+	columnPrivileges = map[string]bool{
+		"INSERT": true, "REFERENCES": true, "SELECT": true, "UPDATE": true,
+	}
+)
+
+// On returns the privilege level in GRANT syntax: *.*, `db`.*, `db`.`table` or
+// PROCEDURE `db`.`routine`
+func (g Grant) On() string {
+	if g.Database == "" {
+		return "*.*"
+	}
+	if g.Table == "" {
+		return fmt.Sprintf("%s.*", quoteIdent(g.Database))
+	}
+	if g.Routine != "" {
+		return fmt.Sprintf("%s %s.%s", g.Routine, quoteIdent(g.Database), quoteIdent(g.Table))
+	}
+	return fmt.Sprintf("%s.%s", quoteIdent(g.Database), quoteIdent(g.Table))
+}
+
+// PrivilegesList returns the comma separated privileges list. For column level grants,
+// each privilege is followed by its columns list.
+func (g Grant) PrivilegesList() string {
+	if len(g.Columns) == 0 {
+		return strings.Join(g.Privileges, ", ")
+	}
+	cols := make([]string, 0, len(g.Columns))
+	for _, col := range g.Columns {
+		cols = append(cols, quoteIdent(col))
+	}
+	privs := make([]string, 0, len(g.Privileges))
+	for _, priv := range g.Privileges {
+		privs = append(privs, fmt.Sprintf("%s (%s)", priv, strings.Join(cols, ", ")))
+	}
+	return strings.Join(privs, ", ")
+}
+
+func (g Grant) String() string {
+	return fmt.Sprintf("%s ON %s", g.PrivilegesList(), g.On())
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// NarrowGrants uses the specified test user to try to reduce the level of the minimum grants
+// found for a testing case from global (*.*) to the schemas, tables and columns referenced by
+// the query. Each level is verified by running the query again and the narrowest level that
+// still works is stored in testCase.ObjectGrants. Privileges that are only valid at the global
+// level (PROCESS, SUPER, etc) are always kept at the global level.
+func NarrowGrants(conn *sql.DB, dsnTemplate, user string, testCase *TestingCase) {
+	testCase.ObjectGrants = []Grant{{Privileges: testCase.MinimumGrants}}
+
+	defaultDB := testCase.Database
+	if defaultDB == "" {
+		if cfg, err := mysql.ParseDSN(fmt.Sprintf(dsnTemplate, "user", "pass")); err == nil {
+			defaultDB = cfg.DBName
+		}
+	}
+
+	objects := testCase.Objects(defaultDB)
+	if len(objects.Databases) == 0 {
+		return
+	}
+	for _, db := range objects.Databases {
+		if db == "" {
+			return
+		}
+	}
+
+	global, schema := splitPrivileges(testCase.MinimumGrants, schemaPrivileges)
+	if len(schema) == 0 {
+		return
+	}
+	globalGrants := []Grant{}
+	if len(global) > 0 {
+		globalGrants = append(globalGrants, Grant{Privileges: global})
+	}
+
+	// Schema level: db.*
+	grants := concatGrants(globalGrants, schemaGrants(schema, objects.Databases))
+	if !grantsAreEnough(conn, dsnTemplate, user, testCase, grants) {
+		return
+	}
+	testCase.ObjectGrants = grants
+
+	// Table level: db.table
+	schema, table := splitPrivileges(schema, tablePrivileges)
+	if len(table) == 0 || len(objects.Tables) == 0 {
+		return
+	}
+	base := concatGrants(globalGrants, schemaGrants(schema, objects.Databases))
+	tableGrants := []Grant{}
+	for _, t := range objects.Tables {
+		tableGrants = append(tableGrants, Grant{Privileges: table, Database: t.Database, Table: t.Name})
+	}
+	grants = concatGrants(base, tableGrants)
+	if !grantsAreEnough(conn, dsnTemplate, user, testCase, grants) {
+		return
+	}
+	testCase.ObjectGrants = grants
+
+	// Column level: PRIV (col1, col2) ON db.table
+	table, column := splitPrivileges(table, columnPrivileges)
+	if len(column) == 0 || !objects.ColumnsKnown {
+		return
+	}
+	columnGrants := []Grant{}
+	for _, t := range objects.Tables {
+		if len(table) > 0 {
+			columnGrants = append(columnGrants, Grant{Privileges: table, Database: t.Database, Table: t.Name})
+		}
+		if len(t.Columns) > 0 {
+			columnGrants = append(columnGrants, Grant{Privileges: column, Database: t.Database, Table: t.Name,
+				Columns: t.Columns})
+		}
+	}
+	grants = concatGrants(base, columnGrants)
+	if !grantsAreEnough(conn, dsnTemplate, user, testCase, grants) {
+		return
+	}
+	testCase.ObjectGrants = grants
+}
+
+func schemaGrants(privileges []string, databases []string) []Grant {
+	grants := []Grant{}
+	if len(privileges) == 0 {
+		return grants
+	}
+	for _, db := range databases {
+		grants = append(grants, Grant{Privileges: privileges, Database: db})
+	}
+	return grants
+}
+
+func concatGrants(lists ...[]Grant) []Grant {
+	grants := []Grant{}
+	for _, list := range lists {
+		grants = append(grants, list...)
+	}
+	return grants
+}
+
+// splitPrivileges splits a privileges list in two lists: privileges not included in the
+// allowed map and privileges included in it.
+func splitPrivileges(privileges []string, allowed map[string]bool) ([]string, []string) {
+	notAllowed, ok := []string{}, []string{}
+	for _, priv := range privileges {
+		if allowed[priv] {
+			ok = append(ok, priv)
+			continue
+		}
+		notAllowed = append(notAllowed, priv)
+	}
+	return notAllowed, ok
+}
+
+// grantsAreEnough creates a test user having the specified grants and runs the testing case
+// query to check if the grants are enough to run it.
+func grantsAreEnough(conn *sql.DB, dsnTemplate, user string, testCase *TestingCase, grants []Grant) bool {
+	probe, err := probeQuery(conn, dsnTemplate, user, grants, testCase)
+	if err != nil {
+		log.Debug().Msgf("Cannot create a test connection with grants %v: %s", grants, err)
+		return false
+	}
+
+	return probe.MinimumGrants != nil
+}
+
+// probeQuery runs the testing case query using a test user having the specified grants and
+// returns a copy of the testing case having the results. The testing case is not modified.
+func probeQuery(conn *sql.DB, dsnTemplate, user string, grants []Grant, testCase *TestingCase) (*TestingCase, error) {
+	tc, err := NewTestConnectionWithGrants(conn, dsnTemplate, user, grants)
+	if err != nil {
+		return nil, err
+	}
+	defer tc.Destroy()
+
+	probe := testCase.Probe()
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	tc.testQuery(probe, wg)
+
+	return probe, nil
+}
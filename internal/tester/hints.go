@@ -0,0 +1,137 @@
+package tester
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// 1142: SELECT command denied to user 'u'@'h' for table 't'
+	// 1143: SELECT command denied to user 'u'@'h' for column 'c' in table 't'
+	// 1370: execute command denied to user 'u'@'h' for routine 'db.p'
+	commandDeniedRe = regexp.MustCompile(`^(.+?) command denied to user`)
+	// 1227: Access denied; you need (at least one of) the SUPER or SYSTEM_VARIABLES_ADMIN privilege(s) for this operation
+	needPrivilegesRe = regexp.MustCompile(`you need \(at least one of\) the (.+?) privilege\(s\)`)
+	// 1419: You do not have the SUPER privilege and binary logging is enabled
+	doNotHaveRe = regexp.MustCompile(`You do not have the (.+?) privilege`)
+)
+
+// RequiredPrivileges parses an access denied error and returns the privileges named in the
+// error message. If the message lists alternatives, like "SUPER or SYSTEM_VARIABLES_ADMIN",
+// anyOf is true meaning any of the returned privileges is enough.
+// It returns an empty list if the error doesn't name the missing privileges, like
+// 1044: Access denied for user 'u'@'h' to database 'db'.
+func RequiredPrivileges(err error) (privileges []string, anyOf bool) {
+	me, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return nil, false
+	}
+
+	var list string
+	switch me.Number {
+	case 1142, 1143, 1370:
+		if m := commandDeniedRe.FindStringSubmatch(me.Message); m != nil {
+			list = m[1]
+		}
+	case 1227:
+		if m := needPrivilegesRe.FindStringSubmatch(me.Message); m != nil {
+			list = m[1]
+			anyOf = true
+		}
+	case 1419:
+		if m := doNotHaveRe.FindStringSubmatch(me.Message); m != nil {
+			list = m[1]
+		}
+	}
+	if list == "" {
+		return nil, false
+	}
+
+	for _, alternative := range strings.Split(list, " or ") {
+		for _, priv := range strings.Split(alternative, ",") {
+			if priv = strings.ToUpper(strings.TrimSpace(priv)); priv != "" {
+				privileges = append(privileges, priv)
+			}
+		}
+	}
+
+	return privileges, anyOf && len(privileges) > 1
+}
+
+// SearchWithErrorHints tries to find the minimum grants for a testing case starting with no
+// privileges and adding the privileges named in the access denied errors, until the query
+// can be executed. Only privileges included in the grants list are used. The privileges found
+// are then reduced, removing the ones the query can run without.
+// It returns false if an error doesn't name the missing privileges or if maxDepth privileges
+// were added without success. In that case, a combinations search is needed.
+func SearchWithErrorHints(conn *sql.DB, dsnTemplate, user string, grants []string, maxDepth int,
+	testCase *TestingCase) bool {
+	available := make(map[string]bool, len(grants))
+	for _, grant := range grants {
+		available[grant] = true
+	}
+
+	current := []string{}
+	for len(current) < maxDepth {
+		privileges := current
+		if len(privileges) == 0 {
+			privileges = []string{"USAGE"}
+		}
+
+		probe, err := probeQuery(conn, dsnTemplate, user, []Grant{{Privileges: privileges}}, testCase)
+		if err != nil {
+			log.Debug().Msgf("Cannot create a test connection with grants %v: %s", privileges, err)
+			return false
+		}
+
+		testCase.Error = probe.Error
+		testCase.LastTestedGrants = probe.LastTestedGrants
+
+		if probe.InvalidQuery {
+			testCase.InvalidQuery = true
+			return true
+		}
+		if probe.MinimumGrants != nil {
+			// A privilege added for an earlier error might not be needed having the ones added later
+			if len(privileges) > 1 {
+				privileges = reduceGrants(conn, dsnTemplate, user, privileges, testCase)
+			}
+			testCase.MinimumGrants = privileges
+			return true
+		}
+		if !probe.NotAllowed {
+			return false
+		}
+
+		required, anyOf := RequiredPrivileges(probe.Error)
+		next := []string{}
+		for _, priv := range required {
+			if available[priv] && !contains(current, priv) {
+				next = append(next, priv)
+			}
+		}
+		if len(next) == 0 {
+			return false
+		}
+		if anyOf {
+			next = SortByRisk(next)[:1]
+		}
+		log.Debug().Msgf("Query %q needs %v. Adding %v", testCase.Query, required, next)
+		current = append(current, next...)
+	}
+
+	return false
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package tester
+
+import (
+	"database/sql"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SearchByReduction finds a minimal set of grants for a testing case starting with all the
+// grants and removing them one at a time, as long as the query can still be executed.
+// It needs len(grants) + 1 probes per query, but the result is minimal only in the sense that
+// no single privilege can be removed from it; a smaller set of different privileges might exist.
+// The grants must be grantable to the test user (see GrantablePrivileges).
+// It returns false if the query cannot be executed even with all the grants.
+func SearchByReduction(conn *sql.DB, dsnTemplate, user string, grants []string, testCase *TestingCase) bool {
+	current := append([]string{}, grants...)
+
+	ok, err := grantsAllowQuery(conn, dsnTemplate, user, current, testCase)
+	if err != nil {
+		log.Debug().Msgf("Cannot create a test connection with all grants: %s", err)
+		return false
+	}
+	if testCase.InvalidQuery {
+		return true
+	}
+	if !ok {
+		return false
+	}
+
+	testCase.MinimumGrants = reduceGrants(conn, dsnTemplate, user, current, testCase)
+	return true
+}
+
+// reduceGrants removes the privileges that are not needed to execute the testing case query, one
+// at a time, keeping a removal only if the query can still be executed. It returns USAGE if no
+// privilege is needed.
+func reduceGrants(conn *sql.DB, dsnTemplate, user string, privileges []string, testCase *TestingCase) []string {
+	current := append([]string{}, privileges...)
+	for i := 0; i < len(current); {
+		candidate := append(append([]string{}, current[:i]...), current[i+1:]...)
+		if ok, err := grantsAllowQuery(conn, dsnTemplate, user, candidate, testCase); err == nil && ok {
+			current = candidate
+			continue
+		}
+		i++
+	}
+
+	if len(current) == 0 {
+		current = []string{"USAGE"}
+	}
+	return current
+}
+
+// GrantablePrivileges returns the privileges that can be granted to a test user, probing them one
+// at a time. Some privileges cannot be granted in all servers, like SUPER in Amazon RDS, and
+// granting them together with the others would make every query fail.
+func GrantablePrivileges(conn *sql.DB, dsnTemplate, user string, privileges []string) []string {
+	grantable := []string{}
+	for _, priv := range privileges {
+		tc, err := NewTestConnectionWithGrants(conn, dsnTemplate, user, []Grant{{Privileges: []string{priv}}})
+		if err != nil {
+			log.Info().Msgf("Cannot grant %s to the test user: %s. Skipping", priv, err)
+			continue
+		}
+		tc.Destroy()
+		grantable = append(grantable, priv)
+	}
+	return grantable
+}
+
+// grantsAllowQuery returns true if the testing case query can be executed by a test user having
+// the specified global privileges. The testing case error fields are updated with the results.
+func grantsAllowQuery(conn *sql.DB, dsnTemplate, user string, privileges []string, testCase *TestingCase) (bool, error) {
+	if len(privileges) == 0 {
+		privileges = []string{"USAGE"}
+	}
+	probe, err := probeQuery(conn, dsnTemplate, user, []Grant{{Privileges: privileges}}, testCase)
+	if err != nil {
+		return false, err
+	}
+
+	testCase.LastTestedGrants = probe.LastTestedGrants
+	if probe.InvalidQuery || probe.MinimumGrants != nil {
+		testCase.InvalidQuery = probe.InvalidQuery
+		testCase.Error = probe.Error
+	}
+
+	return probe.MinimumGrants != nil, nil
+}
@@ -0,0 +1,110 @@
+package tester
+
+import (
+	"sort"
+)
+
+// Risk levels for the privileges. The risk of a grants list is the sum of the risk of each
+// privilege.
+const (
+	RiskNone   = 0
+	RiskLow    = 1
+	RiskMedium = 3
+	RiskHigh   = 10
+)
+
+// privilegeRisk holds the risk level of each privilege. Privileges not listed here have
+// RiskMedium
+var privilegeRisk = map[string]int{
+	"USAGE":                      RiskNone,
+	"SELECT":                     RiskLow,
+	"INSERT":                     RiskLow,
+	"UPDATE":                     RiskLow,
+	"SHOW VIEW":                  RiskLow,
+	"SHOW DATABASES":             RiskLow,
+	"LOCK TABLES":                RiskLow,
+	"CREATE TEMPORARY TABLES":    RiskLow,
+	"REFERENCES":                 RiskLow,
+	"REPLICATION CLIENT":         RiskLow,
+	"DELETE":                     RiskMedium,
+	"CREATE":                     RiskMedium,
+	"ALTER":                      RiskMedium,
+	"DROP":                       RiskMedium,
+	"INDEX":                      RiskMedium,
+	"CREATE VIEW":                RiskMedium,
+	"CREATE ROUTINE":             RiskMedium,
+	"ALTER ROUTINE":              RiskMedium,
+	"EXECUTE":                    RiskMedium,
+	"EVENT":                      RiskMedium,
+	"TRIGGER":                    RiskMedium,
+	"PROCESS":                    RiskMedium,
+	"RELOAD":                     RiskMedium,
+	"REPLICATION SLAVE":          RiskMedium,
+	"CREATE TABLESPACE":          RiskMedium,
+	"CONNECTION_ADMIN":           RiskMedium,
+	"SUPER":                      RiskHigh,
+	"FILE":                       RiskHigh,
+	"SHUTDOWN":                   RiskHigh,
+	"GRANT OPTION":               RiskHigh,
+	"CREATE USER":                RiskHigh,
+	"BINLOG_ADMIN":               RiskHigh,
+	"ENCRYPTION_KEY_ADMIN":       RiskHigh,
+	"GROUP_REPLICATION_ADMIN":    RiskHigh,
+	"REPLICATION_SLAVE_ADMIN":    RiskHigh,
+	"ROLE_ADMIN":                 RiskHigh,
+	"SET_USER_ID":                RiskHigh,
+	"SYSTEM_VARIABLES_ADMIN":     RiskHigh,
+	"SYSTEM_USER":                RiskHigh,
+	"BACKUP_ADMIN":               RiskHigh,
+	"CLONE_ADMIN":                RiskHigh,
+	"AUDIT_ADMIN":                RiskHigh,
+	"PERSIST_RO_VARIABLES_ADMIN": RiskHigh,
+	"TABLE_ENCRYPTION_ADMIN":     RiskHigh,
+	"INNODB_REDO_LOG_ARCHIVE":    RiskHigh,
+	"CREATE ROLE":                RiskMedium,
+	"DROP ROLE":                  RiskMedium,
+	"SESSION_VARIABLES_ADMIN":    RiskMedium,
+	"XA_RECOVER_ADMIN":           RiskMedium,
+	"RESOURCE_GROUP_ADMIN":       RiskMedium,
+	"RESOURCE_GROUP_USER":        RiskLow,
+	// MariaDB
+	"BINLOG MONITOR":           RiskLow,
+	"SLAVE MONITOR":            RiskLow,
+	"DELETE HISTORY":           RiskMedium,
+	"CONNECTION ADMIN":         RiskMedium,
+	"BINLOG ADMIN":             RiskHigh,
+	"BINLOG REPLAY":            RiskHigh,
+	"FEDERATED ADMIN":          RiskHigh,
+	"READ_ONLY ADMIN":          RiskHigh,
+	"REPLICATION MASTER ADMIN": RiskHigh,
+	"REPLICATION SLAVE ADMIN":  RiskHigh,
+	"SET USER":                 RiskHigh,
+}
+
+// PrivilegeRisk returns the risk level of a privilege
+func PrivilegeRisk(privilege string) int {
+	if risk, ok := privilegeRisk[privilege]; ok {
+		return risk
+	}
+	return RiskMedium
+}
+
+// GrantsRisk returns the risk score of a list of privileges
+func GrantsRisk(privileges []string) int {
+	risk := 0
+	for _, priv := range privileges {
+		risk += PrivilegeRisk(priv)
+	}
+	return risk
+}
+
+// SortByRisk sorts the privileges from the lowest to the highest risk, keeping the original
+// order for privileges having the same risk level.
+func SortByRisk(privileges []string) []string {
+	sorted := make([]string, len(privileges))
+	copy(sorted, privileges)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return PrivilegeRisk(sorted[i]) < PrivilegeRisk(sorted[j])
+	})
+	return sorted
+}
@@ -0,0 +1,78 @@
+package tester
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/schema"
+)
+
+// DDL statements are committed even if the test transaction is rolled back, so a query like
+// DROP TABLE would destroy the tables used by the next queries, that would then fail with
+// "table doesn't exist". The testing cases running DDL statements are tested alone and the
+// schema objects they use are restored afterwards, running again the schema statements.
+var (
+	schemaMu         sync.RWMutex
+	schemaStatements []string
+)
+
+// SetSchema sets the statements that created the schema, including the stub tables, in the
+// test server. They are used to restore the schema after testing DDL queries.
+func SetSchema(statements []string) {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	schemaStatements = statements
+}
+
+// lockSchema locks the schema for the testing case: exclusively if it can modify the schema,
+// so no other query runs before the schema is restored. It returns the function to unlock it.
+func (tc *TestConnection) lockSchema(testCase *TestingCase) func() {
+	if !testCase.modifiesSchema() {
+		schemaMu.RLock()
+		return schemaMu.RUnlock
+	}
+	schemaMu.Lock()
+	return func() {
+		tc.restoreSchema(testCase)
+		schemaMu.Unlock()
+	}
+}
+
+// modifiesSchema returns true if the testing case runs statements that commit changes to the
+// existing schema objects
+func (tc *TestingCase) modifiesSchema() bool {
+	for _, query := range tc.queries() {
+		fields := strings.Fields(query)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "DROP", "ALTER", "RENAME", "TRUNCATE":
+			return true
+		}
+	}
+	return false
+}
+
+// restoreSchema drops the tables referenced by the testing case, since they might have been
+// altered, and runs again the schema statements to recreate them and any other dropped object.
+func (tc *TestConnection) restoreSchema(testCase *TestingCase) {
+	if len(schemaStatements) == 0 {
+		return
+	}
+	for _, t := range testCase.Objects(tc.database(testCase)).Tables {
+		if t.Database == "" || t.Name == "" || schema.SystemSchemas[strings.ToLower(t.Database)] {
+			continue
+		}
+		query := fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", quoteIdent(t.Database), quoteIdent(t.Name))
+		if _, err := tc.mainConn.Exec(query); err != nil {
+			log.Debug().Msgf("Cannot drop the table to restore it: %s", err)
+		}
+	}
+	if err := schema.Restore(tc.mainConn, schemaStatements); err != nil {
+		log.Error().Msgf("Cannot restore the schema after testing %q: %s", testCase.Query, err)
+	}
+}
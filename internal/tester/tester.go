@@ -9,6 +9,8 @@ import (
 	"github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+
+	"github.com/Percona-Lab/minimum_permissions/internal/qparser"
 )
 
 type TestConnection struct {
@@ -19,26 +21,92 @@ type TestConnection struct {
 	testDSN     string
 	testUser    string
 	testPass    string
+	// defaultDB is the default database in the test DSN
+	defaultDB string
 }
 
 type TestingCase struct {
-	Database         string
-	Query            string
-	Fingerprint      string
-	MinimumGrants    []string
-	LastTestedGrants []string
-	NotAllowed       bool
-	Error            error
-	InvalidQuery     bool
+	Database    string
+	Query       string
+	Fingerprint string
+	SourceFile  string
+	SourceLine  int
+	// Statements, when not empty, are the statements of a session. They are run in order using
+	// the same connection and Query has all of them, for the reports.
+	Statements []string
+	// Prepared is true if the query was run as a prepared statement. It is tested using the
+	// server side prepared statements protocol and its ? placeholders get plausible values.
+	Prepared bool
+	// Users are the accounts that ran the query, when they are known from the log
+	Users         []Account
+	MinimumGrants []string
+	// AlternativeGrants holds other combinations, having the same number of grants as
+	// MinimumGrants, that can also run the query
+	AlternativeGrants [][]string
+	ObjectGrants      []Grant
+	LastTestedGrants  []string
+	NotAllowed        bool
+	Error             error
+	InvalidQuery      bool
+}
+
+// Probe returns a copy of the testing case, without test results, to run the query again
+func (tc *TestingCase) Probe() *TestingCase {
+	return &TestingCase{Database: tc.Database, Query: tc.Query, Statements: tc.Statements, Prepared: tc.Prepared}
+}
+
+// Objects returns the objects referenced by the query or by the session statements
+func (tc *TestingCase) Objects(defaultDB string) *qparser.Objects {
+	if len(tc.Statements) > 0 {
+		return qparser.ParseSession(tc.Statements, defaultDB)
+	}
+	return qparser.Parse(tc.Query, defaultDB)
+}
+
+// usesDefaultDatabase returns true if the query or the session statements reference tables
+// without a database
+func (tc *TestingCase) usesDefaultDatabase() bool {
+	for _, t := range tc.Objects("").Tables {
+		if t.Database == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// queries returns the statements to run for the testing case
+func (tc *TestingCase) queries() []string {
+	if len(tc.Statements) > 0 {
+		return tc.Statements
+	}
+	return []string{tc.Query}
+}
+
+// Account is a MySQL account. For the accounts read from the logs, Host is the client host.
+type Account struct {
+	User string
+	Host string
+}
+
+func (a Account) String() string {
+	return fmt.Sprintf("'%s'@'%s'", strings.Replace(a.User, "'", "''", -1), strings.Replace(a.Host, "'", "''", -1))
 }
 
 func NewTestConnection(conn *sql.DB, dsnTemplate string, grants []string) (*TestConnection, error) {
+	return NewTestConnectionWithGrants(conn, dsnTemplate, "someuser", []Grant{{Privileges: grants}})
+}
+
+// NewTestConnectionWithGrants creates the test user having the specified grants, that can be
+// at global, schema, table or column level. Concurrent test connections must use different users.
+func NewTestConnectionWithGrants(conn *sql.DB, dsnTemplate, user string, grants []Grant) (*TestConnection, error) {
 	tc := &TestConnection{
-		testUser:    "someuser", // utils.RandomString(12),
+		testUser:    user,
 		testPass:    "somepass", // utils.RandomString(12),
 		mainConn:    conn,
 		dsnTemplate: dsnTemplate,
-		grants:      grants,
+	}
+	for _, grant := range grants {
+		tc.grants = append(tc.grants, grant.Privileges...)
 	}
 	if conn == nil {
 		return nil, fmt.Errorf("Main MySQL connection is nil")
@@ -53,16 +121,25 @@ func NewTestConnection(conn *sql.DB, dsnTemplate string, grants []string) (*Test
 	if err != nil {
 		return nil, errors.Wrapf(err, "Cannot create a new testing user: %q", query)
 	}
-	query = fmt.Sprintf("GRANT %s ON *.* TO '%s'@'%%'", strings.Join(grants, ", "), tc.testUser)
-	log.Debug().Msg(query)
-	log.Debug().Msg(strings.Repeat("-", 100))
+	for _, grant := range grants {
+		query = fmt.Sprintf("GRANT %s TO '%s'@'%%'", grant, tc.testUser)
+		log.Debug().Msg(query)
 
-	_, err = tc.mainConn.Exec(query)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Cannot GRANT privileges: %q", query)
+		_, err = tc.mainConn.Exec(query)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Cannot GRANT privileges: %q", query)
+		}
 	}
+	log.Debug().Msg(strings.Repeat("-", 100))
 
 	tc.testDSN = fmt.Sprintf(dsnTemplate, tc.testUser, tc.testPass)
+	if cfg, err := mysql.ParseDSN(tc.testDSN); err == nil {
+		// The default database is selected before each query, so connecting doesn't need a
+		// privilege on it
+		tc.defaultDB = cfg.DBName
+		cfg.DBName = ""
+		tc.testDSN = cfg.FormatDSN()
+	}
 	tc.testConn, err = sql.Open("mysql", tc.testDSN)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Cannot connect to the db using the test connection %q", tc.testDSN)
@@ -112,7 +189,24 @@ func (tc *TestConnection) TestQueries(testCases []*TestingCase, stopChan chan bo
 
 func (tc *TestConnection) testQuery(testCase *TestingCase, wg *sync.WaitGroup) {
 	defer wg.Done()
-	tx, err := tc.testConn.Begin()
+	unlock := tc.lockSchema(testCase)
+	defer unlock()
+
+	conn := tc.testConn
+	if len(testCase.Statements) > 0 {
+		// Each session runs in a new connection so it doesn't see the user variables, temporary
+		// tables or prepared statements of other sessions
+		session, err := sql.Open("mysql", tc.testDSN)
+		if err != nil {
+			testCase.Error = err
+			return
+		}
+		defer session.Close()
+		session.SetMaxOpenConns(1)
+		conn = session
+	}
+
+	tx, err := conn.Begin()
 	if err != nil {
 		testCase.Error = err
 		return
@@ -121,7 +215,40 @@ func (tc *TestConnection) testQuery(testCase *TestingCase, wg *sync.WaitGroup) {
 
 	testCase.Error = nil
 	testCase.NotAllowed = false
-	_, err = tx.Exec(testCase.Query)
+	// Run the query in its default database, as it was run when it was logged. Using it needs a
+	// privilege on it, so queries not having unqualified objects, like SHOW ENGINE INNODB STATUS,
+	// run without it if the test user cannot use it.
+	useDenied := false
+	if db := tc.database(testCase); db != "" {
+		_, err = tx.Exec("USE " + quoteIdent(db))
+		if me, ok := err.(*mysql.MySQLError); ok && me.Number == 1044 && !testCase.usesDefaultDatabase() {
+			useDenied = true
+			err = nil
+		}
+	}
+	// 1046: No database selected. It is caused by a missing privilege only if the default database
+	// could not be used.
+	denied := func(err error) bool {
+		me, ok := err.(*mysql.MySQLError)
+		return accessDenied(err) || (useDenied && ok && me.Number == 1046)
+	}
+	// A session keeps running after the errors not caused by missing privileges, like a duplicate
+	// key, so the privileges needed by the next statements are also checked. The first error is
+	// kept, unless there is an access denied error.
+	for _, query := range testCase.queries() {
+		if err != nil && (denied(err) || len(testCase.Statements) == 0) {
+			break
+		}
+		var queryErr error
+		if testCase.Prepared {
+			queryErr = execPrepared(tx, query)
+		} else {
+			_, queryErr = tx.Exec(query)
+		}
+		if queryErr != nil && (err == nil || denied(queryErr)) {
+			err = queryErr
+		}
+	}
 
 	if err == nil {
 		testCase.MinimumGrants = tc.grants
@@ -130,24 +257,18 @@ func (tc *TestConnection) testQuery(testCase *TestingCase, wg *sync.WaitGroup) {
 		testCase.Error = err
 		testCase.LastTestedGrants = tc.grants
 
-		me, _ := err.(*mysql.MySQLError)
-		switch me.Number {
-		case 1064: // Syntax error
-			testCase.InvalidQuery = true
-			break
-		// 1044: Access denied for user '%s'@'%s' to database '%s'
-		// 1045: Access denied for user '%s'@'%s' to database '%s' (Example: LOAD DATA INFILE)
-		// 1095: Kill denied (You are not owner of thread %lu)
-		// 1142: Table access denied (%s command denied to user '%s'@'%s' for table '%s')
-		// 1143: Access denied to column (%s command denied to user '%s'@'%s' for column '%s' in table '%s')
-		// 1227 (0x4cb): Specific access denied (you need (at least one of) the %s privilege(s) for this operation)
-		// 1419 (0x58b): You do not have the SUPER privilege and binary logging is enabled
-		// 1370: Process access denied (%s command denied to user '%s'@'%s' for routine '%s')
-		// 1873: Access denied: change user (Access denied trying to change to user '%s'@'%s' (using password: %s). Disconnecting.)
-		// 3202: Keyring access denied. (Access denied; you need %s privileges for this operation)
-		case 1044, 1045, 1095, 1142, 1143, 1227, 1419: //, 1370, 1873, 3202:
+		me, ok := err.(*mysql.MySQLError)
+		switch {
+		// Driver errors, like a lost connection or a wrong number of placeholder values, mean the
+		// query was not run, so the grants are unknown
+		case !ok:
+			log.Debug().Msgf("Cannot test %q: %s", testCase.Query, err)
+		case denied(err):
 			testCase.NotAllowed = true
-			break
+		// Syntax error. A syntax error in a session statement doesn't make the session invalid
+		// since the other statements were tested
+		case me.Number == 1064 && len(testCase.Statements) == 0:
+			testCase.InvalidQuery = true
 		// For these, we know for sure the query can be executed
 		// 1049: Database doesn't exists
 		// 1067 (0x42B): Invalid default value for
@@ -155,15 +276,62 @@ func (tc *TestConnection) testQuery(testCase *TestingCase, wg *sync.WaitGroup) {
 		// 1213 (0x4bd): Deadlock
 		// 1215 (0x4bf): Cannot add FK constraint
 		// 1231 (0x4cf): Invalid value for variable
-		case 1049, 1146, 1067, 1213, 1215, 1231: // Database doesn't exist but it was able to run the query
-			testCase.MinimumGrants = tc.grants
-			break
 		default:
 			testCase.MinimumGrants = tc.grants
 		}
 	}
 }
 
+// accessDenied returns true if the error is caused by missing privileges
+func accessDenied(err error) bool {
+	me, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return false
+	}
+	switch me.Number {
+	// 1044: Access denied for user '%s'@'%s' to database '%s'
+	// 1045: Access denied for user '%s'@'%s' to database '%s' (Example: LOAD DATA INFILE)
+	// 1095: Kill denied (You are not owner of thread %lu)
+	// 1142: Table access denied (%s command denied to user '%s'@'%s' for table '%s')
+	// 1143: Access denied to column (%s command denied to user '%s'@'%s' for column '%s' in table '%s')
+	// 1227 (0x4cb): Specific access denied (you need (at least one of) the %s privilege(s) for this operation)
+	// 1419 (0x58b): You do not have the SUPER privilege and binary logging is enabled
+	// 1370: Process access denied (%s command denied to user '%s'@'%s' for routine '%s')
+	// 1873: Access denied: change user (Access denied trying to change to user '%s'@'%s' (using password: %s). Disconnecting.)
+	// 3202: Keyring access denied. (Access denied; you need %s privileges for this operation)
+	case 1044, 1045, 1095, 1142, 1143, 1227, 1419: //, 1370, 1873, 3202:
+		return true
+	}
+	return false
+}
+
+// execPrepared runs the query using the prepared statements protocol, giving the placeholders
+// values guessed from the query. Statements not supported by the protocol run as text, having
+// the values in place of the placeholders.
+func execPrepared(tx *sql.Tx, query string) error {
+	stmt, err := tx.Prepare(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	// 1295: This command is not supported in the prepared statement protocol yet
+	if me, ok := err.(*mysql.MySQLError); ok && me.Number == 1295 {
+		_, err = tx.Exec(qparser.Interpolate(query))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(qparser.PlaceholderValues(query)...)
+	return err
+}
+
+// database returns the default database for the testing case: the database recorded in the
+// log or the test DSN database
+func (tc *TestConnection) database(testCase *TestingCase) string {
+	if testCase.Database != "" {
+		return testCase.Database
+	}
+	return tc.defaultDB
+}
+
 func (tc *TestConnection) User() string {
 	return tc.testUser
 }
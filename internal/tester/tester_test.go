@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 
@@ -32,7 +33,7 @@ func TestMain(m *testing.M) {
 	cfg.MultiStatements = true
 	dsn = cfg.FormatDSN()
 
-	templateDSN := fmt.Sprintf("%%s:%%s@%s(%s)/?autocommit=0", cfg.Net, cfg.Addr)
+	templateDSN = fmt.Sprintf("%%s:%%s@%s(%s)/?autocommit=0", cfg.Net, cfg.Addr)
 	log.Printf("Test DSN: %q", dsn)
 	log.Printf("Template DSN: %q", templateDSN)
 
@@ -147,3 +148,376 @@ func TestTestQuery(t *testing.T) {
 		tc.Destroy()
 	}
 }
+
+func TestRequiredPrivileges(t *testing.T) {
+	tests := []struct {
+		Err        error
+		Privileges []string
+		AnyOf      bool
+	}{
+		{
+			Err:        &mysql.MySQLError{Number: 1142, Message: "SELECT command denied to user 'someuser'@'localhost' for table 't'"},
+			Privileges: []string{"SELECT"},
+		},
+		{
+			Err:        &mysql.MySQLError{Number: 1143, Message: "UPDATE command denied to user 'someuser'@'localhost' for column 'c' in table 't'"},
+			Privileges: []string{"UPDATE"},
+		},
+		{
+			Err:        &mysql.MySQLError{Number: 1370, Message: "execute command denied to user 'someuser'@'%' for routine 'd1.p'"},
+			Privileges: []string{"EXECUTE"},
+		},
+		{
+			Err:        &mysql.MySQLError{Number: 1227, Message: "Access denied; you need (at least one of) the PROCESS privilege(s) for this operation"},
+			Privileges: []string{"PROCESS"},
+		},
+		{
+			Err:        &mysql.MySQLError{Number: 1227, Message: "Access denied; you need (at least one of) the SUPER or SYSTEM_VARIABLES_ADMIN privilege(s) for this operation"},
+			Privileges: []string{"SUPER", "SYSTEM_VARIABLES_ADMIN"},
+			AnyOf:      true,
+		},
+		{
+			Err:        &mysql.MySQLError{Number: 1227, Message: "Access denied; you need (at least one of) the SUPER, REPLICATION CLIENT privilege(s) for this operation"},
+			Privileges: []string{"SUPER", "REPLICATION CLIENT"},
+			AnyOf:      true,
+		},
+		{
+			Err:        &mysql.MySQLError{Number: 1419, Message: "You do not have the SUPER privilege and binary logging is enabled (you *might* want to use the less safe log_bin_trust_function_creators variable)"},
+			Privileges: []string{"SUPER"},
+		},
+		{
+			Err: &mysql.MySQLError{Number: 1044, Message: "Access denied for user 'someuser'@'%' to database 'd1'"},
+		},
+		{
+			Err: fmt.Errorf("not a MySQL error"),
+		},
+	}
+
+	for i, test := range tests {
+		privileges, anyOf := RequiredPrivileges(test.Err)
+		tu.Equals(t, test.Privileges, privileges)
+		tu.Assert(t, anyOf == test.AnyOf, "#%d: anyOf should be %v", i, test.AnyOf)
+	}
+}
+
+func TestReduceGrants(t *testing.T) {
+	testCase := &TestingCase{Query: "SHOW ENGINE INNODB STATUS"}
+	grants := reduceGrants(db, templateDSN, "min_perms_test", []string{"SELECT", "PROCESS", "RELOAD"}, testCase)
+	tu.Equals(t, grants, []string{"PROCESS"})
+
+	testCase = &TestingCase{Query: "SELECT 1"}
+	grants = reduceGrants(db, templateDSN, "min_perms_test", []string{"SELECT", "PROCESS"}, testCase)
+	tu.Equals(t, grants, []string{"USAGE"})
+}
+
+func TestSearchByReduction(t *testing.T) {
+	grants := GrantablePrivileges(db, templateDSN, "min_perms_test",
+		[]string{"SELECT", "NOT_A_PRIVILEGE", "PROCESS", "RELOAD"})
+	tu.Equals(t, grants, []string{"SELECT", "PROCESS", "RELOAD"})
+
+	testCase := &TestingCase{Query: "SHOW ENGINE INNODB STATUS"}
+	tu.Assert(t, SearchByReduction(db, templateDSN, "min_perms_test", grants, testCase), "Grants not found")
+	tu.Equals(t, testCase.MinimumGrants, []string{"PROCESS"})
+
+	testCase = &TestingCase{Query: "SHOW ENGINE INNODB STATUS"}
+	tu.Assert(t, !SearchByReduction(db, templateDSN, "min_perms_test", []string{"SELECT"}, testCase),
+		"The query cannot run having only SELECT")
+}
+
+func TestModifiesSchema(t *testing.T) {
+	tu.Assert(t, (&TestingCase{Query: "drop table d1.t"}).modifiesSchema(), "DROP modifies the schema")
+	tu.Assert(t, (&TestingCase{Query: "TRUNCATE d1.t"}).modifiesSchema(), "TRUNCATE modifies the schema")
+	tu.Assert(t, (&TestingCase{Statements: []string{"SELECT 1", "ALTER TABLE t ADD c INT"}}).modifiesSchema(),
+		"ALTER in a session modifies the schema")
+	tu.Assert(t, !(&TestingCase{Query: "CREATE TABLE d1.t2 (i INT)"}).modifiesSchema(), "CREATE keeps the schema")
+	tu.Assert(t, !(&TestingCase{Query: "SELECT `drop` FROM d1.t"}).modifiesSchema(), "SELECT keeps the schema")
+}
+
+func TestRestoreSchema(t *testing.T) {
+	statements := []string{
+		"CREATE DATABASE IF NOT EXISTS d2",
+		"CREATE TABLE IF NOT EXISTS `d2`.`t` (`i` TEXT)",
+	}
+	_, err := db.Exec("DROP DATABASE IF EXISTS d2")
+	tu.IsNil(t, err)
+	defer db.Exec("DROP DATABASE IF EXISTS d2") // nolint
+	for _, stmt := range statements {
+		_, err := db.Exec(stmt)
+		tu.IsNil(t, err)
+	}
+	SetSchema(statements)
+	defer SetSchema(nil)
+
+	for _, query := range []string{"ALTER TABLE d2.t DROP COLUMN i, ADD j INT", "DROP TABLE d2.t", "DROP DATABASE d2"} {
+		tc, err := NewTestConnection(db, templateDSN, []string{"ALTER", "DROP"})
+		tu.IsNil(t, err)
+		testCase := &TestingCase{Query: query}
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		tc.testQuery(testCase, wg)
+		wg.Wait()
+		tc.Destroy()
+		tu.IsNil(t, testCase.Error)
+
+		// The next queries must find the table as it was created
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM information_schema.columns " +
+			"WHERE table_schema = 'd2' AND table_name = 't' AND column_name = 'i'").Scan(&count)
+		tu.IsNil(t, err)
+		tu.Assert(t, count == 1, "%q: d2.t was not restored", query)
+	}
+}
+
+func TestSortByRisk(t *testing.T) {
+	grants := []string{"SUPER", "SELECT", "PROCESS", "USAGE", "FILE", "INSERT"}
+	want := []string{"USAGE", "SELECT", "INSERT", "PROCESS", "SUPER", "FILE"}
+	tu.Equals(t, SortByRisk(grants), want)
+	tu.Equals(t, GrantsRisk([]string{"SELECT", "SUPER"}), RiskLow+RiskHigh)
+	tu.Equals(t, PrivilegeRisk("UNKNOWN_ADMIN"), RiskMedium)
+}
+
+func TestParseGrants(t *testing.T) {
+	showGrants := `+-------------------------------------------------------------------------+
+| Grants for app@%                                                        |
++-------------------------------------------------------------------------+
+| GRANT PROCESS, REPLICATION CLIENT ON *.* TO 'app'@'%'                   |
+| GRANT SELECT, INSERT ON ` + "`sakila`" + `.* TO 'app'@'%' WITH GRANT OPTION     |
+| GRANT SELECT (` + "`id`, `name`" + `), UPDATE ON ` + "`sakila`.`actor`" + ` TO 'app'@'%' |
+| GRANT ` + "`reader`@`%`" + ` TO 'app'@'%'                                         |
+| GRANT PROXY ON ''@'' TO 'app'@'%'                                       |
++-------------------------------------------------------------------------+
+`
+	want := []Grant{
+		{Privileges: []string{"PROCESS", "REPLICATION CLIENT"}},
+		{Privileges: []string{"SELECT", "INSERT", "GRANT OPTION"}, Database: "sakila"},
+		{Privileges: []string{"UPDATE"}, Database: "sakila", Table: "actor"},
+		{Privileges: []string{"SELECT"}, Database: "sakila", Table: "actor", Columns: []string{"id", "name"}},
+	}
+	got, err := ParseGrants(strings.NewReader(showGrants))
+	tu.Ok(t, err)
+	tu.Equals(t, got, want)
+
+	got, err = ParseGrants(strings.NewReader("GRANT ALL ON db.* TO u;\nGRANT USAGE ON *.* TO u;\n"))
+	tu.Ok(t, err)
+	tu.Equals(t, got, []Grant{{Privileges: []string{"ALL PRIVILEGES"}, Database: "db"}, {Privileges: []string{"USAGE"}}})
+
+	got, err = ParseGrants(strings.NewReader("GRANT EXECUTE ON PROCEDURE `db`.`p` TO u"))
+	tu.Ok(t, err)
+	tu.Equals(t, got, []Grant{{Privileges: []string{"EXECUTE"}, Database: "db", Table: "p", Routine: "PROCEDURE"}})
+	tu.Equals(t, got[0].String(), "EXECUTE ON PROCEDURE `db`.`p`")
+
+	_, err = ParseGrants(strings.NewReader("GRANT SELECT ON db TO u"))
+	tu.NotOk(t, err)
+}
+
+func TestWithoutPrivilege(t *testing.T) {
+	grants := []Grant{
+		{Privileges: []string{"PROCESS"}},
+		{Privileges: []string{"SELECT", "INSERT"}, Database: "sakila"},
+	}
+	tu.Equals(t, withoutPrivilege(grants, 1, "SELECT"), []Grant{
+		{Privileges: []string{"PROCESS"}},
+		{Privileges: []string{"INSERT"}, Database: "sakila"},
+	})
+	tu.Equals(t, withoutPrivilege(grants, 0, "PROCESS"), grants[1:])
+	tu.Equals(t, withoutPrivilege(grants[:1], 0, "PROCESS"), []Grant{{Privileges: []string{"USAGE"}}})
+	// The original list must not be modified
+	tu.Equals(t, grants[1].Privileges, []string{"SELECT", "INSERT"})
+}
+
+func TestAllowed(t *testing.T) {
+	tu.Assert(t, allowed([]*TestingCase{{}, {Error: &mysql.MySQLError{Number: 1062}}}), "Server errors don't deny")
+	tu.Assert(t, !allowed([]*TestingCase{{}, {NotAllowed: true}}), "Access denied")
+	tu.Assert(t, !allowed([]*TestingCase{{Error: fmt.Errorf("invalid connection")}}), "Driver errors are not allowed")
+}
+
+func TestCheckGrantsInterrupted(t *testing.T) {
+	stopChan := make(chan bool)
+	close(stopChan)
+	testCases := []*TestingCase{{Query: "SELECT 1"}, {Query: "SHOW ENGINE INNODB STATUS"}}
+	grants := []Grant{{Privileges: []string{"PROCESS", "SUPER"}}}
+
+	// The queries not run are not reported as passed and the unused privileges are not checked
+	res, err := CheckGrants(db, templateDSN, "check_user", grants, testCases, stopChan)
+	tu.Ok(t, err)
+	tu.Assert(t, res.Interrupted, "The check must be interrupted")
+	tu.Equals(t, len(res.Passed), 0)
+	tu.Equals(t, len(res.Untested), 2)
+	tu.Equals(t, len(res.Unused), 0)
+}
+
+func TestTestQueryDefaultDatabase(t *testing.T) {
+	tu.LoadQueriesFromFile(t, "prep.sql")
+
+	tc, err := NewTestConnection(db, templateDSN, []string{"INSERT"})
+	tu.IsNil(t, err)
+	defer tc.Destroy()
+
+	// The unqualified table must be resolved in the testing case database
+	testCase := &TestingCase{Database: "d1", Query: "insert into t values (2)"}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	tc.testQuery(testCase, wg)
+	wg.Wait()
+
+	tu.IsNil(t, testCase.Error)
+	tu.Equals(t, testCase.MinimumGrants, []string{"INSERT"})
+}
+
+func TestTestQueryDefaultDatabaseDenied(t *testing.T) {
+	tu.LoadQueriesFromFile(t, "prep.sql")
+
+	tc, err := NewTestConnection(db, templateDSN, []string{"PROCESS"})
+	tu.IsNil(t, err)
+	defer tc.Destroy()
+
+	// Queries not using the default database don't need a privilege on it
+	testCase := &TestingCase{Database: "d1", Query: "SHOW ENGINE INNODB STATUS"}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	tc.testQuery(testCase, wg)
+	wg.Wait()
+	tu.IsNil(t, testCase.Error)
+	tu.Equals(t, testCase.MinimumGrants, []string{"PROCESS"})
+
+	testCase = &TestingCase{Database: "d1", Query: "SELECT i FROM t"}
+	wg.Add(1)
+	tc.testQuery(testCase, wg)
+	wg.Wait()
+	tu.Assert(t, testCase.NotAllowed, "The query needs a privilege on d1. Error: %v", testCase.Error)
+
+	// Without a default database the query cannot run with any grants
+	testCase = &TestingCase{Query: "SELECT i FROM t"}
+	wg.Add(1)
+	tc.testQuery(testCase, wg)
+	wg.Wait()
+	tu.Assert(t, !testCase.NotAllowed, "No database selected is not an access denied error")
+}
+
+func TestUsesDefaultDatabase(t *testing.T) {
+	tu.Assert(t, (&TestingCase{Query: "SELECT i FROM t"}).usesDefaultDatabase(), "t is in the default database")
+	tu.Assert(t, !(&TestingCase{Query: "SELECT i FROM d1.t"}).usesDefaultDatabase(), "d1.t is qualified")
+	tu.Assert(t, !(&TestingCase{Query: "SHOW ENGINE INNODB STATUS"}).usesDefaultDatabase(), "no tables")
+}
+
+func TestTestQuerySession(t *testing.T) {
+	tu.LoadQueriesFromFile(t, "prep.sql")
+	statements := []string{
+		"CREATE TEMPORARY TABLE d1.tmp (i INT)",
+		"INSERT INTO d1.tmp VALUES (1)",
+		"SELECT i FROM d1.tmp",
+	}
+
+	expects := []struct {
+		Grants     []string
+		NotAllowed bool
+	}{
+		{Grants: []string{"SELECT"}, NotAllowed: true},
+		{Grants: []string{"CREATE TEMPORARY TABLES"}, NotAllowed: false},
+	}
+
+	for i, test := range expects {
+		tc, err := NewTestConnection(db, templateDSN, test.Grants)
+		tu.IsNil(t, err)
+
+		testCase := &TestingCase{Query: strings.Join(statements, ";\n"), Statements: statements}
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		tc.testQuery(testCase, wg)
+		wg.Wait()
+
+		tu.Assert(t, testCase.NotAllowed == test.NotAllowed, "#%d: NotAllowed should be %v. Error: %v",
+			i+1, test.NotAllowed, testCase.Error)
+		tc.Destroy()
+	}
+}
+
+func TestTestQuerySessionErrors(t *testing.T) {
+	tu.LoadQueriesFromFile(t, "prep.sql")
+	// The failing statements must not hide the privileges needed by the next ones
+	statements := []string{
+		"INSERT INTO d1.t VALUES (1, 2)",
+		"SELEC 1",
+		"SHOW ENGINE INNODB STATUS",
+	}
+
+	expects := []struct {
+		Grants     []string
+		NotAllowed bool
+	}{
+		{Grants: []string{"INSERT"}, NotAllowed: true},
+		{Grants: []string{"INSERT", "PROCESS"}, NotAllowed: false},
+	}
+
+	for i, test := range expects {
+		tc, err := NewTestConnection(db, templateDSN, test.Grants)
+		tu.IsNil(t, err)
+
+		testCase := &TestingCase{Query: strings.Join(statements, ";\n"), Statements: statements}
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		tc.testQuery(testCase, wg)
+		wg.Wait()
+
+		tu.Assert(t, testCase.NotAllowed == test.NotAllowed, "#%d: NotAllowed should be %v. Error: %v",
+			i+1, test.NotAllowed, testCase.Error)
+		tu.Assert(t, !testCase.InvalidQuery, "#%d: the session is not invalid", i+1)
+		tc.Destroy()
+	}
+}
+
+func TestTestQueryPrepared(t *testing.T) {
+	tu.LoadQueriesFromFile(t, "prep.sql")
+
+	expects := []struct {
+		Grants     []string
+		NotAllowed bool
+	}{
+		{Grants: []string{"INSERT"}, NotAllowed: true},
+		{Grants: []string{"SELECT"}, NotAllowed: false},
+	}
+
+	for i, test := range expects {
+		tc, err := NewTestConnection(db, templateDSN, test.Grants)
+		tu.IsNil(t, err)
+
+		testCase := &TestingCase{Query: "SELECT i FROM d1.t WHERE i = ? LIMIT ?", Prepared: true}
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		tc.testQuery(testCase, wg)
+		wg.Wait()
+
+		tu.Assert(t, testCase.NotAllowed == test.NotAllowed, "#%d: NotAllowed should be %v. Error: %v",
+			i+1, test.NotAllowed, testCase.Error)
+		tc.Destroy()
+	}
+}
+
+func TestTestQueryPreparedNotSupported(t *testing.T) {
+	tu.LoadQueriesFromFile(t, "prep.sql")
+
+	expects := []struct {
+		Grants     []string
+		NotAllowed bool
+	}{
+		{Grants: []string{"SELECT"}, NotAllowed: true},
+		{Grants: []string{"SELECT", "LOCK TABLES"}, NotAllowed: false},
+	}
+
+	// LOCK TABLES cannot be prepared (1295) so it runs as text
+	for i, test := range expects {
+		tc, err := NewTestConnection(db, templateDSN, test.Grants)
+		tu.IsNil(t, err)
+
+		testCase := &TestingCase{Query: "LOCK TABLES d1.t READ", Prepared: true}
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		tc.testQuery(testCase, wg)
+		wg.Wait()
+
+		tu.Assert(t, testCase.NotAllowed == test.NotAllowed, "#%d: NotAllowed should be %v. Error: %v",
+			i+1, test.NotAllowed, testCase.Error)
+		tu.Equals(t, testCase.MinimumGrants != nil, !test.NotAllowed)
+		tc.Destroy()
+	}
+}
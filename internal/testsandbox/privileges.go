@@ -0,0 +1,187 @@
+package testsandbox
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
+)
+
+// Flavor is the server flavor. Percona Server uses the MySQL privileges.
+type Flavor string
+
+const (
+	FlavorMySQL   Flavor = "mysql"
+	FlavorMariaDB Flavor = "mariadb"
+)
+
+// privilege is a privilege available in a flavor from version since (inclusive) until
+// version until (exclusive). Empty versions mean no limit.
+type privilege struct {
+	name  string
+	since string
+	until string
+}
+
+// notGrantable are the privileges listed by SHOW PRIVILEGES that cannot be granted ON *.*
+var notGrantable = map[string]bool{
+	"PROXY": true,
+}
+
+// privilegesCatalog holds the privileges that can be granted in each flavor. It is used when
+// the privileges cannot be discovered from the server.
+// https://dev.mysql.com/doc/refman/8.0/en/grant.html#grant-privileges
+// https://mariadb.com/kb/en/grant/#privilege-levels
+var privilegesCatalog = map[Flavor][]privilege{
+	FlavorMySQL: {
+		{name: "SELECT"}, {name: "INSERT"}, {name: "DELETE"}, {name: "UPDATE"}, {name: "CREATE"},
+		{name: "ALTER"}, {name: "DROP"}, {name: "CREATE TEMPORARY TABLES"}, {name: "ALTER ROUTINE"},
+		{name: "CREATE ROUTINE"}, {name: "CREATE TABLESPACE"}, {name: "CREATE USER"}, {name: "CREATE VIEW"},
+		{name: "EVENT"}, {name: "EXECUTE"}, {name: "FILE"}, {name: "GRANT OPTION"}, {name: "INDEX"},
+		{name: "LOCK TABLES"}, {name: "PROCESS"}, {name: "REFERENCES"}, {name: "RELOAD"},
+		{name: "REPLICATION CLIENT"}, {name: "REPLICATION SLAVE"}, {name: "SHOW DATABASES"},
+		{name: "SHOW VIEW"}, {name: "SHUTDOWN"}, {name: "SUPER"}, {name: "TRIGGER"}, {name: "USAGE"},
+		// Dynamic privileges
+		{name: "BINLOG_ADMIN", since: "8.0.0"}, {name: "CONNECTION_ADMIN", since: "8.0.0"},
+		{name: "ENCRYPTION_KEY_ADMIN", since: "8.0.0"}, {name: "GROUP_REPLICATION_ADMIN", since: "8.0.0"},
+		{name: "REPLICATION_SLAVE_ADMIN", since: "8.0.0"}, {name: "ROLE_ADMIN", since: "8.0.0"},
+		{name: "SET_USER_ID", since: "8.0.0"}, {name: "SYSTEM_VARIABLES_ADMIN", since: "8.0.0"},
+	},
+	FlavorMariaDB: {
+		{name: "SELECT"}, {name: "INSERT"}, {name: "DELETE"}, {name: "UPDATE"}, {name: "CREATE"},
+		{name: "ALTER"}, {name: "DROP"}, {name: "CREATE TEMPORARY TABLES"}, {name: "ALTER ROUTINE"},
+		{name: "CREATE ROUTINE"}, {name: "CREATE TABLESPACE"}, {name: "CREATE USER"}, {name: "CREATE VIEW"},
+		{name: "EVENT"}, {name: "EXECUTE"}, {name: "FILE"}, {name: "GRANT OPTION"}, {name: "INDEX"},
+		{name: "LOCK TABLES"}, {name: "PROCESS"}, {name: "REFERENCES"}, {name: "RELOAD"},
+		{name: "REPLICATION SLAVE"}, {name: "SHOW DATABASES"}, {name: "SHOW VIEW"}, {name: "SHUTDOWN"},
+		{name: "SUPER"}, {name: "TRIGGER"}, {name: "USAGE"},
+		{name: "DELETE HISTORY", since: "10.3.4"},
+		// REPLICATION CLIENT was renamed to BINLOG MONITOR in 10.5.2
+		{name: "REPLICATION CLIENT", until: "10.5.2"},
+		{name: "BINLOG MONITOR", since: "10.5.2"},
+		// SUPER was split in 10.5.2
+		{name: "BINLOG ADMIN", since: "10.5.2"}, {name: "BINLOG REPLAY", since: "10.5.2"},
+		{name: "CONNECTION ADMIN", since: "10.5.2"}, {name: "FEDERATED ADMIN", since: "10.5.2"},
+		{name: "READ_ONLY ADMIN", since: "10.5.2"}, {name: "REPLICATION MASTER ADMIN", since: "10.5.2"},
+		{name: "REPLICATION SLAVE ADMIN", since: "10.5.2"}, {name: "SET USER", since: "10.5.2"},
+		{name: "SLAVE MONITOR", since: "10.5.8"},
+	},
+}
+
+var serverVersionRe = regexp.MustCompile(`^(?:5\.5\.5-)?(\d+\.\d+\.\d+)`)
+
+// ParseServerVersion returns the flavor and the version from a server version string like
+// 8.0.13, 5.7.23-23-log or 10.5.8-MariaDB-1:10.5.8+maria~focal-log
+func ParseServerVersion(vs string) (Flavor, *version.Version, error) {
+	m := serverVersionRe.FindStringSubmatch(vs)
+	if m == nil {
+		return "", nil, fmt.Errorf("cannot parse the server version %q", vs)
+	}
+	v, err := version.NewVersion(m[1])
+	if err != nil {
+		return "", nil, err
+	}
+
+	flavor := FlavorMySQL
+	if strings.Contains(strings.ToLower(vs), "mariadb") {
+		flavor = FlavorMariaDB
+	}
+
+	return flavor, v, nil
+}
+
+// Privileges returns the privileges that can be granted in a flavor and version
+func Privileges(flavor Flavor, v *version.Version) []string {
+	privileges := []string{}
+	for _, priv := range privilegesCatalog[flavor] {
+		if priv.since != "" && v.LessThan(version.Must(version.NewVersion(priv.since))) {
+			continue
+		}
+		if priv.until != "" && !v.LessThan(version.Must(version.NewVersion(priv.until))) {
+			continue
+		}
+		privileges = append(privileges, priv.name)
+	}
+	return privileges
+}
+
+// DiscoverPrivileges returns the privileges that can be granted in the server: the ones listed
+// by SHOW PRIVILEGES plus the ones held by the current user, like the dynamic privileges
+// registered by plugins.
+func DiscoverPrivileges(db *sql.DB) ([]string, error) {
+	privileges := []string{}
+	seen := map[string]bool{}
+	add := func(priv string) {
+		priv = strings.ToUpper(strings.TrimSpace(priv))
+		if priv == "" || seen[priv] || notGrantable[priv] {
+			return
+		}
+		seen[priv] = true
+		privileges = append(privileges, priv)
+	}
+
+	rows, err := db.Query("SHOW PRIVILEGES")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get the privileges list")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var priv, context, comment sql.NullString
+		if err := rows.Scan(&priv, &context, &comment); err != nil {
+			return nil, errors.Wrap(err, "cannot get the privileges list")
+		}
+		add(priv.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot get the privileges list")
+	}
+
+	query := `SELECT DISTINCT PRIVILEGE_TYPE FROM information_schema.USER_PRIVILEGES
+	           WHERE GRANTEE = CONCAT("'", SUBSTRING_INDEX(CURRENT_USER(), '@', 1), "'@'",
+	                                  SUBSTRING_INDEX(CURRENT_USER(), '@', -1), "'")`
+	userRows, err := db.Query(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get the current user privileges")
+	}
+	defer userRows.Close()
+	for userRows.Next() {
+		var priv string
+		if err := userRows.Scan(&priv); err != nil {
+			return nil, errors.Wrap(err, "cannot get the current user privileges")
+		}
+		add(priv)
+	}
+	if err := userRows.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot get the current user privileges")
+	}
+
+	// USAGE is always tested since some queries don't need any privilege
+	add("USAGE")
+
+	return privileges, nil
+}
+
+// FilterPrivileges adds the included privileges to the list and removes the excluded ones.
+// Names are case insensitive.
+func FilterPrivileges(privileges, include, exclude []string) []string {
+	excluded := map[string]bool{}
+	for _, priv := range exclude {
+		excluded[strings.ToUpper(strings.TrimSpace(priv))] = true
+	}
+
+	filtered := []string{}
+	seen := map[string]bool{}
+	for _, priv := range append(append([]string{}, privileges...), include...) {
+		priv = strings.ToUpper(strings.TrimSpace(priv))
+		if priv == "" || seen[priv] || excluded[priv] {
+			continue
+		}
+		seen[priv] = true
+		filtered = append(filtered, priv)
+	}
+
+	return filtered
+}
@@ -37,6 +37,8 @@ type TestSandbox struct {
 	templateDSN    string
 	cleanupActions []*cleanupAction
 	grants         []string
+	flavor         Flavor
+	version        *version.Version
 }
 
 // New returns a new sandbox instance
@@ -90,12 +92,72 @@ func New(mysqlBaseDir string) (*TestSandbox, error) {
 		Args: []interface{}{ts.db},
 	})
 
+	if err := ts.setup(); err != nil {
+		return ts, err
+	}
+
+	return ts, nil
+}
+
+// NewFromServer uses an existing MySQL server instead of starting a new sandbox. The server must
+// be disposable: it must not have other schemas than the system ones or it must have a schema
+// named DisposableMarker. The user must have all privileges WITH GRANT OPTION since the test users
+// are created in this server.
+func NewFromServer(host string, port int, user, password string) (*TestSandbox, error) {
+	var err error
+	ts := &TestSandbox{
+		cleanupActions: []*cleanupAction{},
+		host:           host,
+		user:           user,
+		password:       password,
+		port:           port,
+	}
+
+	ts.db, err = getDBConnection(ts.host, ts.user, ts.password, ts.port)
+	if err != nil {
+		return ts, errors.Wrap(err, "cannot connect to the db")
+	}
+
+	ts.cleanupActions = append(ts.cleanupActions, &cleanupAction{
+		Func: closeDB,
+		Args: []interface{}{ts.db},
+	})
+
+	if err := checkDisposable(ts.db); err != nil {
+		return ts, err
+	}
+
+	// The test users connect to the test database, like in the dbdeployer sandboxes
+	var testDB string
+	err = ts.db.QueryRow("SHOW DATABASES LIKE 'test'").Scan(&testDB)
+	if err == sql.ErrNoRows {
+		if _, err = ts.db.Exec("CREATE DATABASE `test`"); err != nil {
+			return ts, errors.Wrap(err, "cannot create the test database")
+		}
+		ts.cleanupActions = append(ts.cleanupActions, &cleanupAction{Func: dropTempDB, Args: []interface{}{ts.db, "test"}})
+	} else if err != nil {
+		return ts, errors.Wrap(err, "cannot check if the test database exists")
+	}
+
+	protocol, hostPort := getProtocolAndHost(ts.host, ts.port)
+	ts.templateDSN = fmt.Sprintf("%%s:%%s@%s(%s)/%s?autocommit=0", protocol, hostPort, "test")
+
+	if err := ts.setup(); err != nil {
+		return ts, err
+	}
+
+	return ts, nil
+}
+
+// setup checks the user privileges, creates the testing database and gets the grants list
+func (ts *TestSandbox) setup() error {
+	var err error
 	if v, e := validGrants(ts.db); !v || e != nil {
 		if e != nil {
-			return ts, errors.Wrap(err, "cannot check for valid grants")
+			return errors.Wrap(e, "cannot check for valid grants")
 		}
 
-		return ts, errors.Wrap(err, "the user %q must have GRANT OPTION")
+		return fmt.Errorf("the user %q must have GRANT OPTION", ts.user)
 	}
 
 	ts.dbName = fmt.Sprintf("min_perms_test_%04d", rand.Int63n(10000))
@@ -107,16 +169,16 @@ func New(mysqlBaseDir string) (*TestSandbox, error) {
 
 	_, err = ts.db.Exec(createQuery)
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot create the random database %q", ts.dbName)
+		return errors.Wrapf(err, "cannot create the random database %q", ts.dbName)
 	}
 
 	ts.cleanupActions = append(ts.cleanupActions, &cleanupAction{Func: dropTempDB, Args: []interface{}{ts.db, ts.dbName}})
 
 	if ts.grants, err = ts.getAllGrants(); err != nil {
-		return ts, errors.Wrap(err, "cannot get all grants")
+		return errors.Wrap(err, "cannot get all grants")
 	}
 
-	return ts, nil
+	return nil
 }
 
 func (ts *TestSandbox) DB() *sql.DB {
@@ -143,47 +205,47 @@ func (ts *TestSandbox) RunCleanupActions() {
 	}
 }
 
+// DropDatabaseOnCleanup adds a cleanup action to drop a database, like the ones created when
+// loading the schema
+func (ts *TestSandbox) DropDatabaseOnCleanup(name string) {
+	ts.cleanupActions = append(ts.cleanupActions, &cleanupAction{Func: dropTempDB, Args: []interface{}{ts.db, name}})
+}
+
+// Flavor returns the server flavor
+func (ts *TestSandbox) Flavor() Flavor {
+	return ts.flavor
+}
+
+// Version returns the server version
+func (ts *TestSandbox) Version() *version.Version {
+	return ts.version
+}
+
 func (ts *TestSandbox) Grants() []string {
 	return ts.grants
 }
 
 func (ts *TestSandbox) getAllGrants() ([]string, error) {
-	grants := []string{
-		"SELECT", "INSERT", "DELETE", "UPDATE", "CREATE", "ALTER", "DROP",
-		"CREATE TEMPORARY TABLES", "ALTER ROUTINE", "CREATE ROUTINE", "CREATE TABLESPACE",
-		"CREATE USER", "CREATE VIEW", "EVENT", "EXECUTE", "FILE",
-		"GRANT OPTION", "INDEX", "LOCK TABLES", "PROCESS",
-		"REFERENCES", "RELOAD", "REPLICATION CLIENT", "REPLICATION SLAVE",
-		"SHOW DATABASES", "SHOW VIEW", "SHUTDOWN ", "SUPER",
-		"TRIGGER", "USAGE",
-	}
-
-	// Permissible Dynamic Privileges for GRANT and REVOKE (MySQL 8.0+)
-	// https://dev.mysql.com/doc/refman/8.0/en/grant.html#grant-privileges
-	mysql8Grants := []string{
-		"BINLOG_ADMIN", "CONNECTION_ADMIN",
-		"ENCRYPTION_KEY_ADMIN",
-		"GROUP_REPLICATION_ADMIN", "REPLICATION_SLAVE_ADMIN", "ROLE_ADMIN",
-		"SET_USER_ID", "SYSTEM_VARIABLES_ADMIN",
-	}
-
 	var vs string
 	err := ts.db.QueryRow("SELECT VERSION()").Scan(&vs)
 	if err != nil {
 		return nil, err
 	}
 
-	v, err := version.NewVersion(vs)
+	ts.flavor, ts.version, err = ParseServerVersion(vs)
 	if err != nil {
 		return nil, err
 	}
+	log.Info().Msgf("Server flavor: %s, version: %s", ts.flavor, ts.version)
 
-	v80, _ := version.NewVersion("8.0.0")
-	if !v.LessThan(v80) { // there is no >= in version pkg
-		grants = append(grants, mysql8Grants...)
+	privileges, err := DiscoverPrivileges(ts.db)
+	if err != nil {
+		log.Warn().Msgf("Cannot discover the privileges from the server. Using the built-in list: %s", err)
+		return Privileges(ts.flavor, ts.version), nil
 	}
+	log.Debug().Msgf("Privileges discovered from the server: %v", privileges)
 
-	return grants, nil
+	return privileges, nil
 }
 
 func getFreePort() (int, error) {
@@ -284,11 +346,6 @@ func getDBConnection(host, user, password string, port int) (*sql.DB, error) {
 	return db, nil
 }
 
-func (ts *TestSandbox) TemplateDSN() string {
-	protocol, hostPort := getProtocolAndHost(ts.host, ts.port)
-	return fmt.Sprintf("%%s:%%s@%s(%s)/", protocol, hostPort)
-}
-
 func getProtocolAndHost(host string, port int) (string, string) {
 	protocol := "tcp"
 	hostPort := host
@@ -313,6 +370,52 @@ func validGrants(db *sql.DB) (bool, error) {
 	return false, nil
 }
 
+// DisposableMarker is the name of the schema used to mark a server having other schemas as
+// disposable, so it can be used to run the tests
+const DisposableMarker = "min_perms_disposable"
+
+var systemSchemas = map[string]bool{
+	"information_schema": true,
+	"mysql":              true,
+	"performance_schema": true,
+	"sys":                true,
+	"test":               true,
+}
+
+// checkDisposable returns an error if the server has user schemas and it is not marked as
+// disposable
+func checkDisposable(db *sql.DB) error {
+	rows, err := db.Query("SHOW DATABASES")
+	if err != nil {
+		return errors.Wrap(err, "cannot get the databases list")
+	}
+	defer rows.Close()
+
+	userSchemas := []string{}
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return errors.Wrap(err, "cannot get the databases list")
+		}
+		if schema == DisposableMarker {
+			return nil
+		}
+		if !systemSchemas[strings.ToLower(schema)] && !strings.HasPrefix(schema, "min_perms_test_") {
+			userSchemas = append(userSchemas, schema)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "cannot get the databases list")
+	}
+
+	if len(userSchemas) > 0 {
+		return fmt.Errorf("the server is not empty (schemas: %s). Test users will be created in this server. "+
+			"Create a schema named %q to mark it as disposable", strings.Join(userSchemas, ", "), DisposableMarker)
+	}
+
+	return nil
+}
+
 func closeDB(args []interface{}) error {
 	db := args[0].(*sql.DB)
 	return db.Close()
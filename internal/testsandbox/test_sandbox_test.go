@@ -1,5 +1,68 @@
 package testsandbox
 
+import (
+	"testing"
+
+	"github.com/hashicorp/go-version"
+
+	tu "github.com/Percona-Lab/minimum_permissions/internal/testutils"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	tests := []struct {
+		Version string
+		Flavor  Flavor
+		Want    string
+	}{
+		{Version: "8.0.13", Flavor: FlavorMySQL, Want: "8.0.13"},
+		{Version: "5.7.23-23-log", Flavor: FlavorMySQL, Want: "5.7.23"},
+		{Version: "10.5.8-MariaDB-1:10.5.8+maria~focal-log", Flavor: FlavorMariaDB, Want: "10.5.8"},
+		{Version: "5.5.5-10.2.19-MariaDB", Flavor: FlavorMariaDB, Want: "10.2.19"},
+	}
+	for _, test := range tests {
+		flavor, v, err := ParseServerVersion(test.Version)
+		tu.IsNil(t, err)
+		tu.Equals(t, test.Flavor, flavor)
+		tu.Equals(t, test.Want, v.String())
+	}
+
+	_, _, err := ParseServerVersion("unknown")
+	tu.NotOk(t, err)
+}
+
+func TestFilterPrivileges(t *testing.T) {
+	privileges := []string{"SELECT", "SUPER", "PROCESS", "USAGE"}
+	got := FilterPrivileges(privileges, []string{"audit_admin", "Process"}, []string{"super"})
+	tu.Equals(t, []string{"SELECT", "PROCESS", "USAGE", "AUDIT_ADMIN"}, got)
+}
+
+func TestPrivileges(t *testing.T) {
+	has := func(privileges []string, priv string) bool {
+		for _, p := range privileges {
+			if p == priv {
+				return true
+			}
+		}
+		return false
+	}
+
+	my57 := Privileges(FlavorMySQL, version.Must(version.NewVersion("5.7.23")))
+	tu.Assert(t, !has(my57, "SYSTEM_VARIABLES_ADMIN"), "SYSTEM_VARIABLES_ADMIN is not available in MySQL 5.7")
+	my80 := Privileges(FlavorMySQL, version.Must(version.NewVersion("8.0.13")))
+	tu.Assert(t, has(my80, "SYSTEM_VARIABLES_ADMIN"), "SYSTEM_VARIABLES_ADMIN is available in MySQL 8.0")
+
+	mdb102 := Privileges(FlavorMariaDB, version.Must(version.NewVersion("10.2.19")))
+	tu.Assert(t, has(mdb102, "REPLICATION CLIENT"), "REPLICATION CLIENT is available in MariaDB 10.2")
+	tu.Assert(t, !has(mdb102, "DELETE HISTORY"), "DELETE HISTORY is not available in MariaDB 10.2")
+	tu.Assert(t, !has(mdb102, "SYSTEM_VARIABLES_ADMIN"), "MySQL dynamic privileges are not available in MariaDB")
+
+	mdb105 := Privileges(FlavorMariaDB, version.Must(version.NewVersion("10.5.8")))
+	tu.Assert(t, !has(mdb105, "REPLICATION CLIENT"), "REPLICATION CLIENT was renamed in MariaDB 10.5.2")
+	for _, priv := range []string{"BINLOG MONITOR", "SLAVE MONITOR", "READ_ONLY ADMIN", "DELETE HISTORY"} {
+		tu.Assert(t, has(mdb105, priv), priv+" is available in MariaDB 10.5.8")
+	}
+}
+
 // func TestGetAllGrants57(t *testing.T) {
 // 	tu.SkipIfGreatherThan(t, "5.7.99")
 //
@@ -8,7 +71,7 @@ package testsandbox
 // 		"CREATE ROUTINE", "CREATE TABLESPACE", "CREATE TEMPORARY TABLES", "CREATE USER",
 // 		"CREATE VIEW", "DROP", "EVENT", "EXECUTE", "FILE", "GRANT OPTION", "INDEX",
 // 		"LOCK TABLES", "PROCESS", "REFERENCES", "RELOAD", "REPLICATION CLIENT",
-// 		"REPLICATION SLAVE", "SHOW DATABASES", "SHOW VIEW", "SHUTDOWN ", "SUPER", "TRIGGER", "USAGE",
+// 		"REPLICATION SLAVE", "SHOW DATABASES", "SHOW VIEW", "SHUTDOWN", "SUPER", "TRIGGER", "USAGE",
 // 	}
 //
 // 	sandbox, err := testsandbox.New(opts.mysqlBaseDir)
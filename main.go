@@ -2,12 +2,16 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/briandowns/spinner"
@@ -16,30 +20,69 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 
 	"github.com/alecthomas/kingpin"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/hashicorp/go-version"
 	"github.com/pkg/errors"
 
+	"github.com/Percona-Lab/minimum_permissions/internal/audit"
+	"github.com/Percona-Lab/minimum_permissions/internal/combinations"
+	"github.com/Percona-Lab/minimum_permissions/internal/qparser"
 	"github.com/Percona-Lab/minimum_permissions/internal/qreader"
 	"github.com/Percona-Lab/minimum_permissions/internal/report"
+	"github.com/Percona-Lab/minimum_permissions/internal/routines"
+	"github.com/Percona-Lab/minimum_permissions/internal/schema"
 	"github.com/Percona-Lab/minimum_permissions/internal/tester"
 	"github.com/Percona-Lab/minimum_permissions/internal/testsandbox"
 	"github.com/Percona-Lab/minimum_permissions/internal/utils"
 )
 
 type cliOptions struct {
-	mysqlBaseDir       string
+	mysqlBaseDirs      []string
 	maxDepth           int
+	includePrivileges  []string
+	excludePrivileges  []string
+	allAlternatives    bool
+	stateFile          string
+	resume             bool
+	state              *searchState
+	strategy           string
+	workers            int
 	noTrimLongQueries  bool
 	trimQuerySize      int
 	hideInvalidQueries bool
+	noObjectGrants     bool
+	noErrorHints       bool
+	outputFormat       string
+	grantsUser         string
+	grantsHost         string
+	grantsRoles        bool
+	rolePrefix         string
 	keepSandbox        bool
+	checkGrants        string
+	auditDSN           string
+	auditAccounts      []string
+	routines           bool
+	schemaFile         string
+	schemaFromDSN      string
+	schemaDatabases    []string
+	noStubSchema       bool
 	query              []string
 	inputFile          string
 	slowLog            string
 	genLog             string
+	perfSchemaFile     string
+	groupByAccount     bool
+	sessions           bool
+	filterUser         string
+	filterHost         string
+	filterDB           string
+	since              string
+	until              string
+	filter             qreader.Filter
 	showVersion        bool
 	debug              bool
 	quiet              bool
+	dsn                string
 	host               string
 	port               int
 	user               string
@@ -79,20 +122,6 @@ func main() {
 		log.Fatal().Msg(err.Error())
 	}
 
-	opts.mysqlBaseDir = utils.ExpandHomeDir(opts.mysqlBaseDir)
-	if err := verifyBaseDir(opts.mysqlBaseDir); err != nil {
-		log.Fatal().Msgf("MySQL binaries not found in %q", opts.mysqlBaseDir)
-	}
-
-	sandbox, err := testsandbox.New(opts.mysqlBaseDir)
-	if err != nil {
-		log.Fatal().Msgf("Cannot start the MySQL sandbox: %s", err)
-	}
-
-	if !opts.keepSandbox {
-		defer sandbox.RunCleanupActions()
-	}
-
 	if terminal.IsTerminal(int(os.Stdout.Fd())) {
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}) //nolint
 	}
@@ -104,15 +133,28 @@ func main() {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
 
+	baseDirs := []string{}
+	if opts.host == "" {
+		if baseDirs, err = expandBaseDirs(opts.mysqlBaseDirs); err != nil {
+			log.Fatal().Msg(err.Error())
+		}
+		// The search state is saved for the version being tested when the search is interrupted
+		if opts.resume && len(baseDirs) > 1 {
+			log.Fatal().Msg("--resume cannot be used testing several MySQL versions")
+		}
+	}
+
 	log.Info().Msg("Building the test cases list")
-	testCases, err := buildTestCasesList(opts.query, opts.slowLog, opts.inputFile, opts.genLog)
+	testCases, err := buildTestCasesList(opts.query, opts.slowLog, opts.inputFile, opts.genLog,
+		opts.perfSchemaFile, opts.filter, opts.sessions)
 	if err != nil {
 		log.Error().Msgf("Cannot build the test cases list: %s", err)
 		return
 	}
-	if len(testCases) == 0 {
+	if len(testCases) == 0 && !opts.routines {
 		log.Error().Msg("Test cases list is empty.")
-		log.Error().Msg("Please use --slow-log and/or --input-file and/or --test-statement parameters")
+		log.Error().Msg("Please use --slow-log and/or --gen-log and/or --perf-schema-file and/or --input-file and/or " +
+			"--query parameters")
 		return
 	}
 	log.Info().Msgf("Total number of queries to test: %d", len(testCases))
@@ -123,14 +165,21 @@ func main() {
 		log.Debug().Msgf("%04d: %s", i, tc.Query)
 	}
 
-	grants := sandbox.Grants()
-	// Start the spinner only if running in a terminal and if verbose has not been
-	// specified, otherwise, the spinner will mess the output
-	s := spinner.New(spinner.CharSets[9], 100*time.Millisecond) //nolint
+	statements, err := readSchema(opts.schemaFile, opts.schemaFromDSN, opts.schemaDatabases)
+	if err != nil {
+		log.Error().Msgf("Cannot read the schema: %s", err)
+		return
+	}
+	if opts.routines && len(statements) == 0 {
+		log.Error().Msg("--routines needs the schema having the routines. Use --schema-file or --schema-from-dsn")
+		return
+	}
 
-	if terminal.IsTerminal(int(os.Stdout.Fd())) {
-		if !opts.quiet && !opts.debug {
-			s.Start()
+	var checkGrants []tester.Grant
+	if opts.checkGrants != "" {
+		if checkGrants, err = readCheckGrants(opts.checkGrants); err != nil {
+			log.Error().Msg(err.Error())
+			return
 		}
 	}
 
@@ -145,38 +194,556 @@ func main() {
 		log.Info().Msg("CTRL+C detected. Finishing ...")
 	}()
 
-	results, invalidQueries := test(testCases, sandbox.DB(), sandbox.TemplateDSN(),
-		grants, opts.maxDepth, stopChan, opts.quiet)
+	if opts.host != "" {
+		log.Info().Msgf("Using the existing server at %s:%d", opts.host, opts.port)
+		sandbox, err := testsandbox.NewFromServer(opts.host, opts.port, opts.user, opts.password)
+		if err != nil {
+			sandbox.RunCleanupActions()
+			log.Fatal().Msgf("Cannot use the MySQL server: %s", err)
+		}
+		if !opts.keepSandbox {
+			defer sandbox.RunCleanupActions()
+		}
+		if checkGrants != nil || opts.auditDSN != "" || opts.routines {
+			if err := runMode(opts, sandbox, testCases, statements, checkGrants, stopChan); err != nil {
+				log.Error().Msg(err.Error())
+			}
+			return
+		}
+		results, invalidQueries, err := runSearch(opts, sandbox, testCases, statements, stopChan)
+		if err != nil {
+			log.Error().Msg(err.Error())
+			return
+		}
+		printResults(opts, results, invalidQueries)
+		return
+	}
+
+	// When testing several MySQL versions, each one is tested in its own sandbox using a copy of
+	// the testing cases
+	versions := []report.VersionResults{}
+	for _, baseDir := range baseDirs {
+		log.Info().Msgf("Testing the queries using the MySQL binaries in %s", baseDir)
+		sandbox, err := testsandbox.New(baseDir)
+		if err != nil {
+			sandbox.RunCleanupActions()
+			if len(baseDirs) == 1 {
+				log.Fatal().Msgf("Cannot start the MySQL sandbox: %s", err)
+			}
+			log.Error().Msgf("Cannot start the MySQL sandbox for %s: %s. Skipping", baseDir, err)
+			continue
+		}
+
+		vTestCases := testCases
+		if len(baseDirs) > 1 {
+			vTestCases = copyTestCases(testCases)
+		}
+		if checkGrants != nil || opts.auditDSN != "" || opts.routines {
+			if len(baseDirs) > 1 {
+				version := filepath.Base(baseDir)
+				fmt.Printf("### Version: %s %s\n\n", version, strings.Repeat("#", 85-len(version)))
+			}
+			err := runMode(opts, sandbox, vTestCases, statements, checkGrants, stopChan)
+			if !opts.keepSandbox {
+				sandbox.RunCleanupActions()
+			}
+			if err != nil {
+				log.Error().Msg(err.Error())
+				return
+			}
+			continue
+		}
+		results, invalidQueries, err := runSearch(opts, sandbox, vTestCases, statements, stopChan)
+		if !opts.keepSandbox {
+			sandbox.RunCleanupActions()
+		}
+		if err != nil {
+			log.Error().Msg(err.Error())
+			return
+		}
+
+		versions = append(versions, report.VersionResults{
+			Version:        filepath.Base(baseDir),
+			Results:        results,
+			InvalidQueries: invalidQueries,
+		})
+
+		stop := false
+		select {
+		case <-stopChan:
+			stop = true
+		default:
+		}
+		if stop {
+			break
+		}
+	}
+
+	if checkGrants != nil || opts.auditDSN != "" || opts.routines {
+		return
+	}
+
+	switch len(versions) {
+	case 0:
+		log.Error().Msg("Cannot test the queries in any MySQL version")
+	case 1:
+		printResults(opts, versions[0].Results, versions[0].InvalidQueries)
+	default:
+		if err := printVersionsReport(opts, versions); err != nil {
+			log.Error().Msgf("Cannot print the report: %s", err)
+		}
+	}
+}
+
+// runSearch loads the schema and searches the minimum grants for the testing cases in a sandbox.
+// It returns the queries having grants and the invalid queries.
+func runSearch(opts cliOptions, sandbox *testsandbox.TestSandbox, testCases []*tester.TestingCase,
+	statements []string, stopChan chan bool) ([]*tester.TestingCase, []*tester.TestingCase, error) {
+	if opts.grantsRoles && (sandbox.Flavor() != testsandbox.FlavorMySQL ||
+		sandbox.Version().LessThan(version.Must(version.NewVersion("8.0.0")))) {
+		log.Warn().Msgf("Roles are available since MySQL 8.0. The server is %s %s", sandbox.Flavor(), sandbox.Version())
+	}
+
+	if err := prepareSchema(opts, sandbox, testCases, statements); err != nil {
+		return nil, nil, err
+	}
+
+	results, invalidQueries := search(opts, sandbox, testCases, stopChan)
+	return results, invalidQueries, nil
+}
+
+// search searches the minimum grants for the testing cases in a sandbox already having the schema.
+// It returns the queries having grants and the invalid queries.
+func search(opts cliOptions, sandbox *testsandbox.TestSandbox, testCases []*tester.TestingCase,
+	stopChan chan bool) ([]*tester.TestingCase, []*tester.TestingCase) {
+	// Lower risk privileges are tested first so, at the same number of grants, they are preferred
+	grants := tester.SortByRisk(testsandbox.FilterPrivileges(sandbox.Grants(),
+		splitList(opts.includePrivileges), splitList(opts.excludePrivileges)))
+	log.Debug().Msgf("Privileges to test: %v", grants)
+	// Start the spinner only if running in a terminal and if verbose has not been
+	// specified, otherwise, the spinner will mess the output
+	s := spinner.New(spinner.CharSets[9], 100*time.Millisecond) //nolint
+
+	if terminal.IsTerminal(int(os.Stdout.Fd())) {
+		if !opts.quiet && !opts.debug {
+			s.Start()
+		}
+	}
+
+	db, templateDSN := sandbox.DB(), sandbox.TemplateDSN()
+	results, invalidQueries := []*tester.TestingCase{}, []*tester.TestingCase{}
+	if !opts.noErrorHints && !opts.allAlternatives {
+		log.Info().Msg("Searching the minimum grants using the privileges named in the access denied errors")
+		results, invalidQueries, testCases = searchEach(testCases, opts.workers, stopChan,
+			func(user string, tc *tester.TestingCase) bool {
+				return tester.SearchWithErrorHints(db, templateDSN, user, grants, opts.maxDepth, tc)
+			})
+		log.Info().Msgf("Found grants for %d queries. %d queries need a %s search",
+			len(results), len(testCases), opts.strategy)
+	}
+
+	if len(testCases) > 0 {
+		var r, i []*tester.TestingCase
+		switch opts.strategy {
+		case "reduce":
+			grantable := tester.GrantablePrivileges(db, templateDSN, workerUser(0), grants)
+			r, i, testCases = searchEach(testCases, opts.workers, stopChan, func(user string, tc *tester.TestingCase) bool {
+				return tester.SearchByReduction(db, templateDSN, user, grantable, tc)
+			})
+			for _, tc := range testCases {
+				log.Info().Msgf("Cannot run this query even with all grants: %s", tc.Query)
+			}
+		default:
+			r, i = test(testCases, db, templateDSN, grants, opts.state, opts.stateFile, opts.maxDepth,
+				opts.workers, opts.allAlternatives, stopChan, opts.quiet)
+		}
+		results = append(results, r...)
+		invalidQueries = append(invalidQueries, i...)
+	}
+
+	if !opts.noObjectGrants {
+		log.Info().Msg("Narrowing the grants to the schemas, tables and columns used by the queries")
+		narrowGrants(results, db, templateDSN, opts.workers, stopChan)
+	}
 
 	if terminal.IsTerminal(int(os.Stdout.Fd())) && !opts.quiet && !opts.debug {
 		s.Stop()
 	}
 
-	if !opts.hideInvalidQueries || opts.debug {
-		report.PrintInvalidQueries(invalidQueries, os.Stdout)
+	return results, invalidQueries
+}
+
+// prepareSchema loads the schema and the stub schema for the testing cases into the sandbox
+func prepareSchema(opts cliOptions, sandbox *testsandbox.TestSandbox, testCases []*tester.TestingCase,
+	statements []string) error {
+	if opts.noStubSchema {
+		statements = append(statements, defaultDatabases(testCases)...)
+	} else {
+		statements = append(statements, stubSchema(testCases, sandbox.TemplateDSN())...)
+	}
+	if len(statements) > 0 {
+		log.Info().Msg("Loading the schema")
+		if err := loadSchema(sandbox, statements); err != nil {
+			return errors.Wrap(err, "Cannot load the schema")
+		}
+	}
+	tester.SetSchema(statements)
+	return nil
+}
+
+// runMode runs the check or the audit mode in the sandbox and prints the results
+func runMode(opts cliOptions, sandbox *testsandbox.TestSandbox, testCases []*tester.TestingCase,
+	statements []string, checkGrants []tester.Grant, stopChan chan bool) error {
+	if opts.routines {
+		objects, err := runRoutines(opts, sandbox, statements, stopChan)
+		if err != nil {
+			return err
+		}
+		printRoutinesResults(opts, objects)
+		return nil
+	}
+
+	if opts.auditDSN != "" {
+		audits, err := runAudit(opts, sandbox, testCases, statements, stopChan)
+		if err != nil {
+			return err
+		}
+		printAuditResults(opts, audits)
+		return nil
+	}
+
+	res, err := runCheck(opts, sandbox, testCases, statements, checkGrants, stopChan)
+	if err != nil {
+		return err
+	}
+	printCheckResult(opts, res)
+	return nil
+}
+
+// runAudit searches the minimum grants for the queries run by each audited account and compares
+// them with the account grants in the audited server
+func runAudit(opts cliOptions, sandbox *testsandbox.TestSandbox, testCases []*tester.TestingCase,
+	statements []string, stopChan chan bool) ([]*audit.AccountAudit, error) {
+	accounts := []tester.Account{}
+	for _, a := range opts.auditAccounts {
+		account, err := audit.ParseAccount(a)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("Use --audit-account to specify the accounts to audit. Users in the logs: %v",
+			audit.Accounts(testCases))
+	}
+
+	if err := prepareSchema(opts, sandbox, testCases, statements); err != nil {
+		return nil, err
+	}
+
+	source, err := sql.Open("mysql", opts.auditDSN)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot connect to the audited server")
+	}
+	defer source.Close()
+
+	audits := []*audit.AccountAudit{}
+	for _, account := range accounts {
+		grants, roles, err := audit.ShowGrants(source, account)
+		if err != nil {
+			return nil, err
+		}
+
+		queries := copyTestCases(audit.Queries(account, testCases))
+		required := []tester.Grant{}
+		if len(queries) == 0 {
+			log.Warn().Msgf("There are no queries run by %s in the logs", account)
+		} else {
+			log.Info().Msgf("Searching the minimum grants for the %d queries run by %s", len(queries), account)
+			results, _ := search(opts, sandbox, queries, stopChan)
+			required = report.MergeGrants(results)
+		}
+
+		a := audit.Compare(account, grants, roles, required)
+		a.Queries = len(queries)
+		audits = append(audits, a)
+	}
+
+	return audits, nil
+}
+
+// printAuditResults prints the audit report in the selected output format
+func printAuditResults(opts cliOptions, audits []*audit.AccountAudit) {
+	var err error
+	switch opts.outputFormat {
+	case "json":
+		err = report.PrintJSON(report.NewAuditReport(audits), os.Stdout)
+	case "yaml":
+		err = report.PrintYAML(report.NewAuditReport(audits), os.Stdout)
+	default:
+		err = report.PrintAuditReport(audits, os.Stdout)
+	}
+	if err != nil {
+		log.Error().Msgf("Cannot print the report: %s", err)
+	}
+}
+
+// runRoutines searches the grants needed to run the body of each stored routine, view and
+// trigger in the schema, and computes the grants needed by their invokers and definers
+func runRoutines(opts cliOptions, sandbox *testsandbox.TestSandbox, statements []string,
+	stopChan chan bool) ([]*routines.Object, error) {
+	definitions := routines.Definitions(statements)
+	if len(definitions) == 0 {
+		return nil, fmt.Errorf("There are no stored routines, views or triggers in the schema")
+	}
+
+	bodies := make([][]*tester.TestingCase, 0, len(definitions))
+	testCases := []*tester.TestingCase{}
+	for _, def := range definitions {
+		tc := routines.TestCases(def)
+		bodies = append(bodies, tc)
+		testCases = append(testCases, tc...)
+	}
+	if err := prepareSchema(opts, sandbox, testCases, statements); err != nil {
+		return nil, err
+	}
+
+	objects := []*routines.Object{}
+	for i, def := range definitions {
+		body := []tester.Grant{}
+		if len(bodies[i]) > 0 {
+			log.Info().Msgf("Searching the minimum grants for the %d statements in %s %s.%s", len(bodies[i]),
+				def.Kind, def.Database, def.Name)
+			results, invalidQueries := search(opts, sandbox, bodies[i], stopChan)
+			for _, tc := range invalidQueries {
+				log.Warn().Msgf("Cannot run this statement of %s %s.%s even with all grants: %s", def.Kind,
+					def.Database, def.Name, tc.Query)
+			}
+			body = report.MergeGrants(results)
+		}
+		objects = append(objects, routines.Analyze(def, body))
+	}
+
+	return objects, nil
+}
+
+// printRoutinesResults prints the stored routines report in the selected output format
+func printRoutinesResults(opts cliOptions, objects []*routines.Object) {
+	var err error
+	switch opts.outputFormat {
+	case "json":
+		err = report.PrintJSON(report.NewRoutinesReport(objects), os.Stdout)
+	case "yaml":
+		err = report.PrintYAML(report.NewRoutinesReport(objects), os.Stdout)
+	default:
+		err = report.PrintRoutinesReport(objects, os.Stdout)
+	}
+	if err != nil {
+		log.Error().Msgf("Cannot print the report: %s", err)
+	}
+}
+
+// runCheck loads the schema and runs the testing cases in a sandbox using exactly the grants
+// being checked
+func runCheck(opts cliOptions, sandbox *testsandbox.TestSandbox, testCases []*tester.TestingCase,
+	statements []string, grants []tester.Grant, stopChan chan bool) (*tester.CheckResult, error) {
+	if err := prepareSchema(opts, sandbox, testCases, statements); err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Checking %d grants against %d queries", len(grants), len(testCases))
+	res, err := tester.CheckGrants(sandbox.DB(), sandbox.TemplateDSN(), "check_user", grants, testCases, stopChan)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot check the grants")
+	}
+	return res, nil
+}
+
+// printCheckResult prints the check mode report in the selected output format
+func printCheckResult(opts cliOptions, res *tester.CheckResult) {
+	var err error
+	switch opts.outputFormat {
+	case "json":
+		err = report.PrintJSON(report.NewCheckReport(res), os.Stdout)
+	case "yaml":
+		err = report.PrintYAML(report.NewCheckReport(res), os.Stdout)
+	default:
+		err = report.PrintCheckReport(res, os.Stdout)
+	}
+	if err != nil {
+		log.Error().Msgf("Cannot print the report: %s", err)
+	}
+}
+
+// readCheckGrants reads the grants to check from a file having SHOW GRANTS output or GRANT
+// statements
+func readCheckGrants(filename string) ([]tester.Grant, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot open %q", filename)
+	}
+	defer fh.Close()
+
+	grants, err := tester.ParseGrants(fh)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot read the grants in %q", filename)
+	}
+	if len(grants) == 0 {
+		return nil, fmt.Errorf("There are no grants in %q", filename)
+	}
+	return grants, nil
+}
+
+// printResults prints the grants script if --grants-user was specified or the report otherwise
+func printResults(opts cliOptions, results, invalidQueries []*tester.TestingCase) {
+	if opts.grantsUser != "" && opts.grantsRoles {
+		rolePrefix := opts.rolePrefix
+		if rolePrefix == "" {
+			rolePrefix = opts.grantsUser + "_role_"
+		}
+		if err := report.PrintRolesScript(results, opts.grantsUser, opts.grantsHost, rolePrefix, os.Stdout); err != nil {
+			log.Error().Msgf("Cannot print the roles script: %s", err)
+		}
+		return
+	}
+
+	if opts.grantsUser != "" {
+		if err := report.PrintGrantsScript(results, opts.grantsUser, opts.grantsHost, os.Stdout); err != nil {
+			log.Error().Msgf("Cannot print the grants script: %s", err)
+		}
+		return
+	}
+
+	if err := printReport(opts, results, invalidQueries); err != nil {
+		log.Error().Msgf("Cannot print the report: %s", err)
+	}
+}
+
+// printVersionsReport prints the results for each MySQL version followed by the queries whose
+// grants are different between versions
+func printVersionsReport(opts cliOptions, versions []report.VersionResults) error {
+	switch opts.outputFormat {
+	case "json":
+		return report.PrintJSON(report.NewVersionsReport(versions), os.Stdout)
+	case "yaml":
+		return report.PrintYAML(report.NewVersionsReport(versions), os.Stdout)
+	}
+
+	for _, v := range versions {
+		fmt.Printf("### Version: %s %s\n\n", v.Version, strings.Repeat("#", 85-len(v.Version)))
+		printResults(opts, v.Results, v.InvalidQueries)
+		fmt.Println()
+	}
+
+	return report.PrintVersionsDiff(versions, os.Stdout)
+}
+
+// copyTestCases returns a copy of the testing cases without the results of previous tests
+func copyTestCases(testCases []*tester.TestingCase) []*tester.TestingCase {
+	copies := make([]*tester.TestingCase, 0, len(testCases))
+	for _, tc := range testCases {
+		copies = append(copies, &tester.TestingCase{
+			Database:    tc.Database,
+			Query:       tc.Query,
+			Statements:  tc.Statements,
+			Prepared:    tc.Prepared,
+			Fingerprint: tc.Fingerprint,
+			SourceFile:  tc.SourceFile,
+			SourceLine:  tc.SourceLine,
+			Users:       tc.Users,
+		})
+	}
+	return copies
+}
+
+// expandBaseDirs verifies the MySQL base directories. A directory not having the MySQL binaries
+// is replaced by its subdirectories having them, so ~/mysql can be used to test all the versions
+// in ~/mysql/my-5.7, ~/mysql/my-8.0, etc.
+func expandBaseDirs(dirs []string) ([]string, error) {
+	baseDirs := []string{}
+	for _, dir := range dirs {
+		dir = utils.ExpandHomeDir(dir)
+		if err := verifyBaseDir(dir); err == nil {
+			baseDirs = append(baseDirs, dir)
+			continue
+		}
+
+		subdirs, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("MySQL binaries not found in %q", dir)
+		}
+		found := false
+		for _, fi := range subdirs {
+			subdir := filepath.Join(dir, fi.Name())
+			if fi.IsDir() && verifyBaseDir(subdir) == nil {
+				baseDirs = append(baseDirs, subdir)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("MySQL binaries not found in %q or its subdirectories", dir)
+		}
+	}
+
+	return baseDirs, nil
+}
+
+func printReport(opts cliOptions, results, invalidQueries []*tester.TestingCase) error {
+	showInvalidQueries := !opts.hideInvalidQueries || opts.debug
+	if !showInvalidQueries {
+		invalidQueries = nil
+	}
+
+	switch opts.outputFormat {
+	case "json":
+		return report.PrintJSON(report.NewReport(results, invalidQueries), os.Stdout)
+	case "yaml":
+		return report.PrintYAML(report.NewReport(results, invalidQueries), os.Stdout)
+	}
+
+	if showInvalidQueries {
+		if err := report.PrintInvalidQueries(invalidQueries, os.Stdout); err != nil {
+			return err
+		}
 	}
 
 	if !opts.noTrimLongQueries {
 		trimQueries(results, opts.trimQuerySize)
 	}
 
-	report.PrintReport(report.GroupResults(results), os.Stdout)
+	if err := report.PrintReport(report.GroupResults(results), os.Stdout); err != nil {
+		return err
+	}
+
+	if err := report.PrintRiskReport(results, os.Stdout); err != nil {
+		return err
+	}
+
+	if opts.groupByAccount {
+		fmt.Println()
+		return report.PrintAccountsReport(results, os.Stdout)
+	}
+	return nil
 }
 
-func buildTestCasesList(query []string, slowLog, plainFile, genLog string) ([]*tester.TestingCase, error) {
+func buildTestCasesList(query []string, slowLog, plainFile, genLog, perfSchemaFile string,
+	filter qreader.Filter, sessions bool) ([]*tester.TestingCase, error) {
 	testCases := []*tester.TestingCase{}
 
+	if !filter.IsEmpty() && (len(query) > 0 || plainFile != "") {
+		log.Warn().Msg("The filters only apply to the slow and general logs and the performance_schema exports. Queries from --query and --input-file are not filtered")
+	}
+
 	if len(query) > 0 {
 		log.Info().Msgf("Adding test statement to the queries list: %q", query)
 
 		for _, query := range query {
-			testCases = append(testCases, &tester.TestingCase{Query: query})
+			testCases = append(testCases, &tester.TestingCase{Query: query, Prepared: qparser.HasPlaceholders(query)})
 		}
 	}
 
 	if slowLog != "" {
 		log.Info().Msgf("Adding queries from slow log file: %q", slowLog)
-		tc, err := qreader.ReadSlowLog(slowLog)
+		tc, err := qreader.ReadSlowLog(slowLog, filter)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Cannot read slow log file %q", slowLog)
 		}
@@ -194,18 +761,32 @@ func buildTestCasesList(query []string, slowLog, plainFile, genLog string) ([]*t
 
 	if genLog != "" {
 		log.Info().Msgf("Adding queries from genlog file: %q", genLog)
-		tc, err := qreader.ReadGeneralLog(genLog)
+		read := qreader.ReadGeneralLog
+		if sessions {
+			read = qreader.ReadGeneralLogSessions
+		}
+		tc, err := read(genLog, filter)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Cannot read genlog file %q", genLog)
 		}
 		testCases = append(testCases, tc...)
 	}
 
+	if perfSchemaFile != "" {
+		log.Info().Msgf("Adding queries from performance_schema export: %q", perfSchemaFile)
+		tc, err := qreader.ReadPerformanceSchema(perfSchemaFile, filter)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Cannot read performance_schema export %q", perfSchemaFile)
+		}
+		testCases = append(testCases, tc...)
+	}
+
 	return testCases, nil
 }
 
 func test(testCases []*tester.TestingCase, db *sql.DB, templateDSN string, grants []string,
-	maxDepth int, stopChan chan bool, quiet bool) ([]*tester.TestingCase, []*tester.TestingCase) {
+	state *searchState, stateFile string, maxDepth, workers int, allAlternatives bool, stopChan chan bool,
+	quiet bool) ([]*tester.TestingCase, []*tester.TestingCase) {
 	results := []*tester.TestingCase{}
 	invalidQueries := []*tester.TestingCase{}
 	stop := false
@@ -213,37 +794,80 @@ func test(testCases []*tester.TestingCase, db *sql.DB, templateDSN string, grant
 	totalQueries := len(testCases)
 	progress := ""
 
-	// grantsCombinations is a slice of slices having all combinations in groups of n
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Combinations are generated lazily, one batch at a time, in groups of n grants
 	// Example: n=2
-	// [
-	//   [SELECT, INSERT],
-	//   [SELECT, UPDATE],
-	//   ...
-	//   [DELETE, UPDATE],
-	//   ...
-	// ]
-
-	for n := 1; n < maxDepth && !stop; n++ {
-		grantsCombinations := getGrantsCombinations(grants, n)
-
-		for j := 0; j < len(grantsCombinations); j++ {
-			grants := grantsCombinations[j]
+	// [SELECT, INSERT], [SELECT, UPDATE], ..., [DELETE, UPDATE], ...
+	// The position in the search (depth and combination index), the grants list and the results
+	// are saved to the state file when the search is interrupted so it can be resumed using
+	// --resume.
+
+	startDepth, startIndex := 1, uint64(0)
+	// found holds the queries whose grants were found at the current depth. If allAlternatives
+	// is set, they are tested with the remaining combinations of the same depth.
+	found := []*tester.TestingCase{}
+	if state != nil {
+		startDepth, startIndex, grants = state.Depth, state.Index, state.Grants
+		results, invalidQueries, found, testCases = state.restore(testCases)
+	}
+
+	for n := startDepth; n < maxDepth && !stop; n++ {
+		// Grants that cannot be granted are removed from the list for the next depth
+		depthGrants := grants
+		// The combinations are tested from the lowest to the highest risk score, so the first one
+		// that can run a query is also the safest one having that number of grants
+		risks := make([]int, len(depthGrants))
+		for i, grant := range depthGrants {
+			risks[i] = tester.PrivilegeRisk(grant)
+		}
+		it := combinations.New(risks, n)
+		if n == startDepth && startIndex > 0 {
+			if err := it.Seek(startIndex); err != nil {
+				log.Error().Msgf("Cannot resume the search: %s", err)
+				break
+			}
+		}
+
+		if n > startDepth {
+			found = []*tester.TestingCase{}
+		}
+		// next is the index of the first combination not fully tested yet
+		next := uint64(0)
+		if n == startDepth {
+			next = startIndex
+		}
+		for !stop {
 			select {
 			case <-stopChan:
 				fmt.Println("")
 				stop = true
+				if stateFile == "" {
+					continue
+				}
+				saved := newSearchState(n, next, depthGrants, results, invalidQueries, found)
+				if err := saved.save(stateFile); err != nil {
+					log.Error().Msgf("Cannot save the search state: %s", err)
+					continue
+				}
+				log.Info().Msgf("Search interrupted. The search state was saved to %s. Use --resume --state-file=%s "+
+					"to resume it", stateFile, stateFile)
 
 				continue
 			default:
 			}
-			testConn, e := tester.NewTestConnection(db, templateDSN, grants)
-			if e != nil {
-				log.Info().Msgf("Cannot grant this/these permissions to the test user: %v: %s", grants, e)
-				log.Info().Msg("Skipping")
-				if len(grants) == 1 {
-					removeGrantFromList(grants, grants[0])
-				}
+
+			if len(testCases) == 0 && len(found) == 0 {
 				stop = true
+				continue
+			}
+			// Each worker tests a different combination. To get the same results no matter the
+			// number of workers, a query is assigned to the first combination in the batch
+			// that can run it, as if the combinations were tested sequentially.
+			batch := nextBatch(it, depthGrants, workers)
+			if len(batch) == 0 {
 				break
 			}
 
@@ -252,43 +876,310 @@ func test(testCases []*tester.TestingCase, db *sql.DB, templateDSN string, grant
 			}
 			progress = progress + "."
 			cleanup := strings.Repeat(" ", 51-len(progress))
-			found := len(results)
 			invalid := len(invalidQueries)
-			remaining := totalQueries - found - invalid
+			remaining := totalQueries - len(results) - invalid
 			if !quiet {
-				fmt.Printf("Found GRANTS for %d queries. Invalid queries found: %d. Still testing %d queries. %s%s\r", found, invalid, remaining, progress, cleanup)
+				fmt.Printf("Found GRANTS for %d queries. Invalid queries found: %d. Still testing %d queries. %s%s\r", len(results), invalid, remaining, progress, cleanup)
+			}
+
+			batchCases := append(append([]*tester.TestingCase{}, testCases...), found...)
+			batchResults := testCombinations(batchCases, db, templateDSN, batch, stopChan)
+			select {
+			case <-stopChan:
+				// The batch may be incomplete. It will be tested again when resuming
+			default:
+				next += uint64(len(batch))
 			}
 
-			tr := testQueries(testConn, testCases, stopChan)
+			for _, br := range batchResults {
+				if br.err != nil {
+					log.Info().Msgf("Cannot grant this/these permissions to the test user: %v: %s", br.grants, br.err)
+					log.Info().Msg("Skipping")
+					if len(br.grants) == 1 {
+						grants = removeGrantFromList(grants, br.grants[0])
+					}
+				}
+			}
 
-			testConn.Destroy()
+			// Queries already having grants only collect the alternatives. The first combination
+			// that worked is in MinimumGrants
+			for i, tc := range found {
+				collectAlternatives(tc, batchResults, len(testCases)+i)
+			}
 
-			results = append(results, tr.OkQueries...)
-			invalidQueries = append(invalidQueries, tr.InvalidQueries...)
-			testCases = tr.NotOkQueries
-			if len(testCases) == 0 {
+			notOk := []*tester.TestingCase{}
+			for i, tc := range testCases {
+				assigned := false
+				for j, br := range batchResults {
+					if br.err != nil {
+						continue
+					}
+					tested := br.testCases[i]
+					tc.Error = tested.Error
+					tc.NotAllowed = tested.NotAllowed
+					tc.LastTestedGrants = tested.LastTestedGrants
+					if tested.InvalidQuery {
+						tc.InvalidQuery = true
+						invalidQueries = append(invalidQueries, tc)
+						assigned = true
+						break
+					}
+					if tested.MinimumGrants != nil {
+						tc.MinimumGrants = tested.MinimumGrants
+						results = append(results, tc)
+						assigned = true
+						if allAlternatives {
+							found = append(found, tc)
+							collectAlternatives(tc, batchResults[j+1:], i)
+						}
+						break
+					}
+				}
+				if !assigned {
+					notOk = append(notOk, tc)
+				}
+			}
+			testCases = notOk
+			if len(testCases) == 0 && !allAlternatives {
 				stop = true
-				break
 			}
 		}
+		if len(testCases) == 0 {
+			stop = true
+		}
 	}
 	fmt.Println()
 
 	return results, invalidQueries
 }
 
+// searchState is the state of an interrupted exhaustive search: the position in the search, the
+// grants list used to build the combinations at that depth and the results found so far.
+type searchState struct {
+	Depth   int                 `json:"depth"`
+	Index   uint64              `json:"index"`
+	Grants  []string            `json:"grants"`
+	Results []searchStateResult `json:"results"`
+}
+
+// searchStateResult holds the grants found for a query. Found is true if they were found at the
+// depth being tested when the search was interrupted, to keep collecting their alternatives.
+type searchStateResult struct {
+	Database          string     `json:"database"`
+	Query             string     `json:"query"`
+	MinimumGrants     []string   `json:"minimum_grants,omitempty"`
+	AlternativeGrants [][]string `json:"alternative_grants,omitempty"`
+	InvalidQuery      bool       `json:"invalid_query,omitempty"`
+	Found             bool       `json:"found,omitempty"`
+}
+
+func newSearchState(depth int, index uint64, grants []string, results, invalidQueries,
+	found []*tester.TestingCase) *searchState {
+	state := &searchState{Depth: depth, Index: index, Grants: grants}
+	current := map[*tester.TestingCase]bool{}
+	for _, tc := range found {
+		current[tc] = true
+	}
+	for _, tc := range append(append([]*tester.TestingCase{}, results...), invalidQueries...) {
+		state.Results = append(state.Results, searchStateResult{
+			Database:          tc.Database,
+			Query:             tc.Query,
+			MinimumGrants:     tc.MinimumGrants,
+			AlternativeGrants: tc.AlternativeGrants,
+			InvalidQuery:      tc.InvalidQuery,
+			Found:             current[tc],
+		})
+	}
+	return state
+}
+
+// readSearchState reads the state saved when a search was interrupted
+func readSearchState(filename string) (*searchState, error) {
+	data, err := ioutil.ReadFile(utils.ExpandHomeDir(filename))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot read the search state from %s", filename)
+	}
+	state := &searchState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrapf(err, "Cannot parse the search state in %s", filename)
+	}
+	if state.Depth < 1 || len(state.Grants) == 0 {
+		return nil, fmt.Errorf("Invalid search state in %s", filename)
+	}
+	return state, nil
+}
+
+func (s *searchState) save(filename string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(utils.ExpandHomeDir(filename), data, 0644)
+}
+
+// restore assigns the saved results to the testing cases. It returns the queries having grants,
+// the invalid queries, the queries whose grants were found at the depth being tested and the
+// queries still without grants.
+func (s *searchState) restore(testCases []*tester.TestingCase) ([]*tester.TestingCase,
+	[]*tester.TestingCase, []*tester.TestingCase, []*tester.TestingCase) {
+	saved := map[string]searchStateResult{}
+	for _, r := range s.Results {
+		saved[r.Database+"\x00"+r.Query] = r
+	}
+
+	results, invalidQueries, found, remaining := []*tester.TestingCase{}, []*tester.TestingCase{},
+		[]*tester.TestingCase{}, []*tester.TestingCase{}
+	for _, tc := range testCases {
+		r, ok := saved[tc.Database+"\x00"+tc.Query]
+		switch {
+		case !ok:
+			remaining = append(remaining, tc)
+		case r.InvalidQuery:
+			tc.InvalidQuery = true
+			invalidQueries = append(invalidQueries, tc)
+		default:
+			tc.MinimumGrants, tc.AlternativeGrants = r.MinimumGrants, r.AlternativeGrants
+			results = append(results, tc)
+			if r.Found {
+				found = append(found, tc)
+			}
+		}
+	}
+	return results, invalidQueries, found, remaining
+}
+
+// collectAlternatives adds to the testing case the grants of the combinations in the batch that
+// can also run the query at index i
+func collectAlternatives(tc *tester.TestingCase, batchResults []combinationResult, i int) {
+	for _, br := range batchResults {
+		if br.err == nil && br.testCases[i].MinimumGrants != nil {
+			tc.AlternativeGrants = append(tc.AlternativeGrants, br.grants)
+		}
+	}
+}
+
+// searchEach runs a search function for each testing case, using a pool of workers. Each worker
+// has its own test user. It returns the queries having grants, the invalid queries and the
+// queries for which the search function failed.
+func searchEach(testCases []*tester.TestingCase, workers int, stopChan chan bool,
+	search func(user string, tc *tester.TestingCase) bool) ([]*tester.TestingCase, []*tester.TestingCase, []*tester.TestingCase) {
+	if workers < 1 {
+		workers = 1
+	}
+	found := make([]bool, len(testCases))
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := range jobs {
+				found[i] = search(workerUser(w), testCases[i])
+			}
+		}(w)
+	}
+
+loop:
+	for i := range testCases {
+		select {
+		case <-stopChan:
+			break loop
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	results, invalidQueries, remaining := []*tester.TestingCase{}, []*tester.TestingCase{}, []*tester.TestingCase{}
+	for i, tc := range testCases {
+		switch {
+		case found[i] && tc.InvalidQuery:
+			invalidQueries = append(invalidQueries, tc)
+		case found[i]:
+			results = append(results, tc)
+		default:
+			remaining = append(remaining, tc)
+		}
+	}
+
+	return results, invalidQueries, remaining
+}
+
+// combinationResult holds the results of testing all queries using a grants combination.
+// testCases are copies of the original testing cases, in the same order.
+type combinationResult struct {
+	grants    []string
+	testCases []*tester.TestingCase
+	err       error
+}
+
+// testCombinations tests all the testing cases with each one of the grants combinations
+// in parallel. Each combination is tested by a different test user.
+func testCombinations(testCases []*tester.TestingCase, db *sql.DB, templateDSN string,
+	combinations [][]string, stopChan chan bool) []combinationResult {
+	results := make([]combinationResult, len(combinations))
+	wg := &sync.WaitGroup{}
+
+	for w, grants := range combinations {
+		wg.Add(1)
+		go func(w int, grants []string) {
+			defer wg.Done()
+			res := combinationResult{grants: grants}
+
+			testConn, err := tester.NewTestConnectionWithGrants(db, templateDSN, workerUser(w),
+				[]tester.Grant{{Privileges: grants}})
+			if err != nil {
+				res.err = err
+				results[w] = res
+				return
+			}
+			defer testConn.Destroy()
+
+			for _, tc := range testCases {
+				probe := tc.Probe()
+				probe.InvalidQuery = tc.InvalidQuery
+				res.testCases = append(res.testCases, probe)
+			}
+			testQueries(testConn, res.testCases, stopChan)
+			results[w] = res
+		}(w, grants)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// workerUser returns the name of the test user used by a worker.
+func workerUser(worker int) string {
+	return fmt.Sprintf("min_perms_%d", worker)
+}
+
 // removeGrantFromList removes a specific grant from the list of grants.
 // This is needed because not in all MySQL servers we can use all grants, for example
 // SUPER is not enabled on Amazon RDS so, if we detect a specific grant cannot be used,
 // we need to remove it from the list of grants to avoid including it in a combination
 // with other grants to speed up the process.
+// It returns a new slice because the combinations of the current depth are still built from
+// the original one.
 func removeGrantFromList(grants []string, grant string) []string {
-	for i := 0; i < len(grants); i++ {
-		if grants[i] == grant {
-			return append(grants[:i], grants[i+1:]...)
+	list := make([]string, 0, len(grants))
+	for _, g := range grants {
+		if g != grant {
+			list = append(list, g)
 		}
 	}
-	return grants
+	return list
+}
+
+// narrowGrants tries to reduce the level of the minimum grants found for each query from *.*
+// to the schemas, tables and columns referenced by the query.
+func narrowGrants(testCases []*tester.TestingCase, db *sql.DB, templateDSN string, workers int,
+	stopChan chan bool) {
+	searchEach(testCases, workers, stopChan, func(user string, tc *tester.TestingCase) bool {
+		tester.NarrowGrants(db, templateDSN, user, tc)
+		return true
+	})
 }
 
 func trimQueries(testCases []*tester.TestingCase, size int) {
@@ -319,61 +1210,122 @@ func testQueries(testConn *tester.TestConnection, testCases []*tester.TestingCas
 	return tr
 }
 
-func getGrantsCombinations(grants []string, length int) [][]string {
-	grantsArray := [][]string{}
+// nextBatch returns the next size grants combinations from the iterator, or less if there are
+// no more combinations
+func nextBatch(it *combinations.Iterator, grants []string, size int) [][]string {
+	batch := [][]string{}
+	for len(batch) < size && it.Next() {
+		batch = append(batch, grantsList(grants, it.Combination()))
+	}
+	return batch
+}
+
+func grantsList(grants []string, indexes []int) []string {
+	grantsList := make([]string, 0, len(indexes))
+	for _, grant := range indexes {
+		grantsList = append(grantsList, grants[grant])
+	}
+	return grantsList
+}
 
-	combinations := comb(len(grants), length)
+// readSchema returns the statements to create the schemas read from the schema file and/or
+// copied from the source server
+func readSchema(schemaFile, schemaDSN string, databases []string) ([]string, error) {
+	statements := []string{}
 
-	for _, combRow := range combinations {
-		grantsList := []string{}
-		for _, grant := range combRow {
-			grantsList = append(grantsList, grants[grant])
+	if schemaFile != "" {
+		fh, err := os.Open(utils.ExpandHomeDir(schemaFile))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Cannot read the schema file %q", schemaFile)
 		}
-		grantsArray = append(grantsArray, grantsList)
+		defer fh.Close()
+
+		stmts, err := schema.Split(fh)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Cannot read the schema file %q", schemaFile)
+		}
+		statements = append(statements, stmts...)
 	}
-	return grantsArray
+
+	if schemaDSN != "" {
+		source, err := sql.Open("mysql", schemaDSN)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot connect to the schema source server")
+		}
+		defer source.Close()
+
+		stmts, err := schema.Dump(source, databases)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot get the schema from the source server")
+		}
+		statements = append(statements, stmts...)
+	}
+
+	return statements, nil
 }
 
-func comb(n, m int) [][]int {
-	s := make([]int, m)
-	combinations := [][]int{}
+// stubSchema returns the statements to create stub schemas and tables for the objects referenced
+// by the queries. Unqualified tables are created in the testing case database or in the default
+// database of the test connections.
+func stubSchema(testCases []*tester.TestingCase, templateDSN string) []string {
+	defaultDB := ""
+	if cfg, err := mysql.ParseDSN(fmt.Sprintf(templateDSN, "user", "pass")); err == nil {
+		defaultDB = cfg.DBName
+	}
 
-	last := m - 1
-	var rc func(int, int)
-	rc = func(i, next int) {
-		for j := next; j < n; j++ {
-			s[i] = j
-			if i == last {
-				ss := make([]int, len(s))
-				copy(ss, s)
-				combinations = append(combinations, ss)
-			} else {
-				rc(i+1, j+1)
-			}
+	objects := make([]*qparser.Objects, 0, len(testCases))
+	for _, tc := range testCases {
+		db := tc.Database
+		if db == "" {
+			db = defaultDB
 		}
-		// return
+		objects = append(objects, tc.Objects(db))
 	}
-	rc(0, 0)
 
-	return combinations
+	return schema.Stubs(append(objects, databaseObjects(testCases)...))
+}
+
+// defaultDatabases returns the statements to create the default databases of the queries, so
+// they can run in their database even if they don't reference any of its tables
+func defaultDatabases(testCases []*tester.TestingCase) []string {
+	return schema.Stubs(databaseObjects(testCases))
 }
 
-func prepare(db *sql.DB, prepareFile string) error {
-	if _, err := os.Stat(prepareFile); err != nil {
-		return errors.Wrapf(err, "Cannot read input file %q", prepareFile)
+func databaseObjects(testCases []*tester.TestingCase) []*qparser.Objects {
+	objects := []*qparser.Objects{}
+	for _, tc := range testCases {
+		if tc.Database != "" {
+			objects = append(objects, &qparser.Objects{Databases: []string{tc.Database}})
+		}
 	}
+	return objects
+}
 
-	cmds, err := ioutil.ReadFile(prepareFile)
+// loadSchema creates the schemas in the sandbox so the queries run against real tables.
+// The new schemas are dropped when cleaning up.
+func loadSchema(sandbox *testsandbox.TestSandbox, statements []string) error {
+	before, err := schema.ListSchemas(sandbox.DB())
 	if err != nil {
-		return errors.Wrap(err, "Cannot prepare environment")
+		return err
 	}
 
-	_, err = db.Exec(string(cmds))
+	loadErr := schema.Load(sandbox.DB(), statements)
+
+	after, err := schema.ListSchemas(sandbox.DB())
 	if err != nil {
-		return errors.Wrap(err, "Cannot prepare environment")
+		return err
+	}
+	existing := map[string]bool{}
+	for _, name := range before {
+		existing[name] = true
+	}
+	for _, name := range after {
+		if !existing[name] {
+			sandbox.DropDatabaseOnCleanup(name)
+		}
 	}
 
-	return nil
+	return loadErr
 }
 
 func verifyBaseDir(dir string) error {
@@ -395,11 +1347,66 @@ func processCliArgs(args []string) (cliOptions, error) {
 	app := kingpin.New("mysql_random_data_loader", "MySQL Random Data Loader")
 	app.HelpFlag.Short('h')
 
-	app.Flag("mysql-base-dir", "Path to the MySQL base directory").Required().StringVar(&opts.mysqlBaseDir)
+	app.Flag("mysql-base-dir", "Path to the MySQL base directory. Can be specified multiple times to compare the "+
+		"grants needed by different versions. A directory having MySQL base directories in its subdirectories "+
+		"tests all of them").StringsVar(&opts.mysqlBaseDirs)
+	app.Flag("dsn", "Use this existing disposable server instead of starting a sandbox. "+
+		"Format: user:password@tcp(host:port)/").StringVar(&opts.dsn)
+	app.Flag("host", "Host of an existing disposable server to use instead of starting a sandbox").
+		StringVar(&opts.host)
+	app.Flag("port", "Port of the existing server").Default("3306").IntVar(&opts.port)
+	app.Flag("user", "User for the existing server. It must have all privileges WITH GRANT OPTION").
+		Default("root").StringVar(&opts.user)
+	app.Flag("password", "Password for the existing server").StringVar(&opts.password)
 	app.Flag("max-depth", "Maximum number of permissions to try").Default("10").IntVar(&opts.maxDepth)
+	app.Flag("all-alternatives", "Keep testing all the combinations with the same number of grants once a query "+
+		"succeeds and report all of them as alternatives. Disables the access denied errors hints").
+		BoolVar(&opts.allAlternatives)
+	app.Flag("state-file", "Save the state of the exhaustive search to this file when it is interrupted").
+		StringVar(&opts.stateFile)
+	app.Flag("resume", "Resume the interrupted exhaustive search saved in --state-file").BoolVar(&opts.resume)
+	app.Flag("include-privileges", "Privileges to test in addition to the ones discovered from the server. "+
+		"Comma separated list. Can be specified multiple times").StringsVar(&opts.includePrivileges)
+	app.Flag("exclude-privileges", "Privileges not to test, like SUPER to find the dynamic privileges that can "+
+		"replace it. Comma separated list. Can be specified multiple times").StringsVar(&opts.excludePrivileges)
+	app.Flag("strategy", "Search strategy: exhaustive tests all grants combinations, from 1 to max-depth grants. "+
+		"reduce grants everything and then removes one grant at a time while the query still succeeds").
+		Default("exhaustive").EnumVar(&opts.strategy, "exhaustive", "reduce")
+	app.Flag("workers", "Number of grants combinations to test in parallel, each one using its own test user").
+		Default("1").IntVar(&opts.workers)
+	app.Flag("no-error-hints",
+		"Don't use the privileges named in the access denied errors. Test all grants combinations instead").
+		BoolVar(&opts.noErrorHints)
 	app.Flag("no-trim-long-queries", "Do not trim long queries").BoolVar(&opts.noTrimLongQueries)
 	app.Flag("trim-query-size", "Trim queries longer than trim-query-size").Default("100").IntVar(&opts.trimQuerySize)
 	app.Flag("hide-invalid-queries", "Don't show invalid queries in the final report").BoolVar(&opts.hideInvalidQueries)
+	app.Flag("no-object-grants", "Do not narrow the grants to the schemas, tables and columns used by the queries").
+		BoolVar(&opts.noObjectGrants)
+	app.Flag("output-format", "Report format: text, json or yaml").Default("text").
+		EnumVar(&opts.outputFormat, "text", "json", "yaml")
+	app.Flag("grants-user", "Print a CREATE USER/GRANT script for this user instead of the report").
+		StringVar(&opts.grantsUser)
+	app.Flag("grants-host", "Host for the user in the CREATE USER/GRANT script").Default("%").StringVar(&opts.grantsHost)
+	app.Flag("schema-file", "Load this schema, like the output of mysqldump --no-data --databases, "+
+		"before testing the queries").StringVar(&opts.schemaFile)
+	app.Flag("schema-from-dsn", "Copy the schema (no data) from this server before testing the queries. "+
+		"Format: user:password@tcp(host:port)/").StringVar(&opts.schemaFromDSN)
+	app.Flag("schema-databases", "Databases to copy from the --schema-from-dsn server. Default: all except the "+
+		"system ones. Can be specified multiple times").StringsVar(&opts.schemaDatabases)
+	app.Flag("no-stub-schema", "Do not create stub schemas and tables for the objects referenced by the queries").
+		BoolVar(&opts.noStubSchema)
+	app.Flag("grants-roles", "With --grants-user, create a MySQL 8 role for each group of queries needing the "+
+		"same grants and grant the roles to the user").BoolVar(&opts.grantsRoles)
+	app.Flag("role-prefix", "Prefix for the roles names. Default: <grants-user>_role_").StringVar(&opts.rolePrefix)
+	app.Flag("check-grants", "Check mode: run the queries with exactly the grants in this file (SHOW GRANTS "+
+		"output or GRANT statements) and report the queries that would fail and the unused privileges").
+		StringVar(&opts.checkGrants)
+	app.Flag("audit-dsn", "Audit mode: compare the grants of the --audit-account accounts in this (read only) "+
+		"server with the minimum grants needed by the queries they run in the logs").StringVar(&opts.auditDSN)
+	app.Flag("audit-account", "Account to audit, in user@host format. Can be specified multiple times").
+		StringsVar(&opts.auditAccounts)
+	app.Flag("routines", "Instead of the queries, compute the grants needed to use the stored routines, views "+
+		"and triggers in the schema and the grants their definers need").BoolVar(&opts.routines)
 	app.Flag("keep-sandbox", "Do not stop/remove the sandbox after finishing").BoolVar(&opts.keepSandbox)
 
 	app.Flag("query", "Query to test. Can be specified multiple times").Short('q').StringsVar(&opts.query)
@@ -408,6 +1415,20 @@ func processCliArgs(args []string) (cliOptions, error) {
 		Short('i').StringVar(&opts.inputFile)
 	app.Flag("slow-log", "Load queries from slow log file").Short('s').StringVar(&opts.slowLog)
 	app.Flag("gen-log", "Load queries from genlog file").Short('g').StringVar(&opts.genLog)
+	app.Flag("perf-schema-file", "Load queries from an export (CSV, tab separated or JSON) of the "+
+		"performance_schema events_statements_history_long or events_statements_summary_by_digest tables").
+		StringVar(&opts.perfSchemaFile)
+	app.Flag("group-by-account", "Also print the minimum grants needed by each account that ran the queries in the logs").
+		BoolVar(&opts.groupByAccount)
+	app.Flag("sessions", "Test the queries of each --gen-log connection together, as a session replayed in a "+
+		"single connection").BoolVar(&opts.sessions)
+	app.Flag("filter-user", "Only test the queries run by this user").StringVar(&opts.filterUser)
+	app.Flag("filter-host", "Only test the queries run from this client host").StringVar(&opts.filterHost)
+	app.Flag("filter-db", "Only test the queries run having this default database").StringVar(&opts.filterDB)
+	app.Flag("since", "Only test the queries run since this time: YYYY-MM-DD [HH:MM[:SS]] or RFC3339").
+		StringVar(&opts.since)
+	app.Flag("until", "Only test the queries run until this time: YYYY-MM-DD [HH:MM[:SS]] or RFC3339").
+		StringVar(&opts.until)
 
 	app.Flag("version", "Show version and exit").BoolVar(&opts.showVersion)
 	app.Flag("debug", "Debug mode").BoolVar(&opts.debug)
@@ -415,6 +1436,79 @@ func processCliArgs(args []string) (cliOptions, error) {
 
 	app.Flag("sandbox-dirname", "Directory name for the sandbox").Default("sandbox").StringVar(&opts.sandboxDirname)
 
-	_, err := app.Parse(args)
-	return opts, err
+	if _, err := app.Parse(args); err != nil {
+		return opts, err
+	}
+
+	if opts.dsn != "" {
+		if err := parseDSN(&opts); err != nil {
+			return opts, err
+		}
+	}
+	if opts.host == "" && len(opts.mysqlBaseDirs) == 0 {
+		return opts, fmt.Errorf("one of --mysql-base-dir, --dsn or --host is required")
+	}
+
+	if opts.allAlternatives && opts.strategy == "reduce" {
+		return opts, fmt.Errorf("--all-alternatives only works with the exhaustive --strategy")
+	}
+
+	var err error
+	opts.filter = qreader.Filter{User: opts.filterUser, Host: opts.filterHost, Database: opts.filterDB}
+	if opts.since != "" {
+		if opts.filter.Since, err = qreader.ParseTime(opts.since); err != nil {
+			return opts, errors.Wrap(err, "invalid --since")
+		}
+	}
+	if opts.until != "" {
+		if opts.filter.Until, err = qreader.ParseTime(opts.until); err != nil {
+			return opts, errors.Wrap(err, "invalid --until")
+		}
+	}
+	if opts.resume {
+		if opts.stateFile == "" {
+			return opts, fmt.Errorf("--resume needs the --state-file of the interrupted search")
+		}
+		if opts.state, err = readSearchState(opts.stateFile); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+// splitList splits the comma separated items of a list of flag values
+func splitList(values []string) []string {
+	list := []string{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// parseDSN sets the host, port, user and password options from the --dsn parameter
+func parseDSN(opts *cliOptions) error {
+	cfg, err := mysql.ParseDSN(opts.dsn)
+	if err != nil {
+		return errors.Wrapf(err, "invalid DSN %q", opts.dsn)
+	}
+	if cfg.Net != "tcp" {
+		return fmt.Errorf("invalid DSN %q: only tcp connections are supported", opts.dsn)
+	}
+
+	host, port, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid address in DSN %q", opts.dsn)
+	}
+	opts.port, err = strconv.Atoi(port)
+	if err != nil {
+		return errors.Wrapf(err, "invalid port in DSN %q", opts.dsn)
+	}
+	opts.host, opts.user, opts.password = host, cfg.User, cfg.Passwd
+
+	return nil
 }
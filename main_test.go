@@ -3,13 +3,17 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
 	_ "github.com/go-sql-driver/mysql"
 	mysql "github.com/go-sql-driver/mysql"
 	"github.com/rs/zerolog/log"
 
+	"github.com/Percona-Lab/minimum_permissions/internal/tester"
 	"github.com/Percona-Lab/minimum_permissions/internal/testsandbox"
 	tu "github.com/Percona-Lab/minimum_permissions/internal/testutils"
 )
@@ -30,7 +34,7 @@ func TestMain(m *testing.M) {
 	cfg.MultiStatements = true
 	dsn = cfg.FormatDSN()
 
-	templateDSN := fmt.Sprintf("%%s:%%s@%s(%s)/?autocommit=0", cfg.Net, cfg.Addr)
+	templateDSN = fmt.Sprintf("%%s:%%s@%s(%s)/?autocommit=0", cfg.Net, cfg.Addr)
 	log.Printf("Test DSN: %q", dsn)
 	log.Printf("Template DSN: %q", templateDSN)
 
@@ -46,21 +50,126 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func TestGetGrantsCombinations(t *testing.T) {
-	grants := []string{"SUPER", "INSERT", "UPDATE"}
-	cmb := getGrantsCombinations(grants, 2)
-	want := [][]string{
-		{"SUPER", "INSERT"},
-		{"SUPER", "UPDATE"},
-		{"INSERT", "UPDATE"},
+func TestRemoveGrantFromList(t *testing.T) {
+	grants := []string{"SELECT", "SUPER", "PROCESS"}
+	tu.Equals(t, removeGrantFromList(grants, "SUPER"), []string{"SELECT", "PROCESS"})
+	tu.Equals(t, grants, []string{"SELECT", "SUPER", "PROCESS"})
+}
+
+func TestTestWithUngrantablePrivilege(t *testing.T) {
+	db, err := sql.Open("mysql", dsn)
+	tu.IsNil(t, err)
+	defer db.Close()
+
+	// The grant after the one that cannot be granted must still be tested at the same depth
+	grants := []string{"SELECT", "NOT_A_PRIVILEGE", "PROCESS", "RELOAD"}
+	testCases := []*tester.TestingCase{{Query: "SHOW ENGINE INNODB STATUS"}}
+	results, invalid := test(testCases, db, templateDSN, grants, nil, "", 3, 1, false, make(chan bool), true)
+	tu.Equals(t, len(invalid), 0)
+	tu.Equals(t, len(results), 1)
+	tu.Equals(t, results[0].MinimumGrants, []string{"PROCESS"})
+}
+
+func TestTestSameResultsAnyWorkers(t *testing.T) {
+	db, err := sql.Open("mysql", dsn)
+	tu.IsNil(t, err)
+	defer db.Close()
+
+	grants := []string{"SELECT", "SUPER", "PROCESS", "RELOAD"}
+	queries := []string{"SELECT 1", "FLUSH TABLES", "SHOW ENGINE INNODB STATUS"}
+	minimumGrants := func(workers int) map[string][]string {
+		testCases := []*tester.TestingCase{}
+		for _, query := range queries {
+			testCases = append(testCases, &tester.TestingCase{Query: query})
+		}
+		results, invalid := test(testCases, db, templateDSN, grants, nil, "", 3, workers, false, make(chan bool), true)
+		tu.Equals(t, len(invalid), 0)
+		found := map[string][]string{}
+		for _, tc := range results {
+			found[tc.Query] = tc.MinimumGrants
+		}
+		return found
 	}
-	tu.Equals(t, cmb, want)
+
+	want := minimumGrants(1)
+	tu.Equals(t, want, map[string][]string{
+		"SELECT 1":                  {"SELECT"},
+		"FLUSH TABLES":              {"RELOAD"},
+		"SHOW ENGINE INNODB STATUS": {"PROCESS"},
+	})
+	tu.Equals(t, minimumGrants(len(grants)), want)
+	tu.Equals(t, minimumGrants(3), want)
 }
 
-func TestCombinationsIndex(t *testing.T) {
-	want := [][]int{{0, 1}, {0, 2}, {1, 2}}
-	cmb := comb(3, 2)
-	tu.Equals(t, cmb, want)
+func TestSearchState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "min_perms_state_")
+	tu.IsNil(t, err)
+	defer os.RemoveAll(dir)
+
+	solved := &tester.TestingCase{Database: "d1", Query: "SELECT * FROM t", MinimumGrants: []string{"SELECT"}}
+	current := &tester.TestingCase{Query: "SHOW ENGINE INNODB STATUS", MinimumGrants: []string{"PROCESS"},
+		AlternativeGrants: [][]string{{"SUPER"}}}
+	invalid := &tester.TestingCase{Query: "SELEC 1", InvalidQuery: true}
+	state := newSearchState(1, 3, []string{"SELECT", "PROCESS", "SUPER"},
+		[]*tester.TestingCase{solved, current}, []*tester.TestingCase{invalid}, []*tester.TestingCase{current})
+
+	filename := filepath.Join(dir, "state.json")
+	tu.IsNil(t, state.save(filename))
+	loaded, err := readSearchState(filename)
+	tu.IsNil(t, err)
+	tu.Equals(t, loaded, state)
+
+	testCases := []*tester.TestingCase{
+		{Database: "d1", Query: "SELECT * FROM t"},
+		{Query: "SELEC 1"},
+		{Query: "FLUSH TABLES"},
+		{Query: "SHOW ENGINE INNODB STATUS"},
+	}
+	results, invalidQueries, found, remaining := loaded.restore(testCases)
+	tu.Equals(t, results, []*tester.TestingCase{solved, current})
+	tu.Equals(t, invalidQueries, []*tester.TestingCase{invalid})
+	tu.Equals(t, found, []*tester.TestingCase{current})
+	tu.Equals(t, remaining, []*tester.TestingCase{{Query: "FLUSH TABLES"}})
+
+	tu.IsNil(t, ioutil.WriteFile(filename, []byte("{}"), os.ModePerm))
+	_, err = readSearchState(filename)
+	tu.NotOk(t, err)
+}
+
+func TestParseDSN(t *testing.T) {
+	opts := cliOptions{dsn: "ci:s3cr3t@tcp(mysql-ci:3307)/"}
+	err := parseDSN(&opts)
+	tu.IsNil(t, err)
+	tu.Equals(t, opts.host, "mysql-ci")
+	tu.Equals(t, opts.port, 3307)
+	tu.Equals(t, opts.user, "ci")
+	tu.Equals(t, opts.password, "s3cr3t")
+
+	opts = cliOptions{dsn: "ci:s3cr3t@unix(/tmp/mysql.sock)/"}
+	tu.NotOk(t, parseDSN(&opts))
+}
+
+func TestExpandBaseDirs(t *testing.T) {
+	parent, err := ioutil.TempDir("", "min_perms_basedirs_")
+	tu.IsNil(t, err)
+	defer os.RemoveAll(parent)
+
+	for _, dir := range []string{"my-5.7", "my-8.0"} {
+		tu.IsNil(t, os.MkdirAll(filepath.Join(parent, dir, "bin"), os.ModePerm))
+		tu.IsNil(t, ioutil.WriteFile(filepath.Join(parent, dir, "bin", "mysqld"), nil, os.ModePerm))
+	}
+	tu.IsNil(t, os.MkdirAll(filepath.Join(parent, "downloads"), os.ModePerm))
+
+	dirs, err := expandBaseDirs([]string{parent})
+	tu.IsNil(t, err)
+	tu.Equals(t, dirs, []string{filepath.Join(parent, "my-5.7"), filepath.Join(parent, "my-8.0")})
+
+	dirs, err = expandBaseDirs([]string{filepath.Join(parent, "my-8.0")})
+	tu.IsNil(t, err)
+	tu.Equals(t, dirs, []string{filepath.Join(parent, "my-8.0")})
+
+	_, err = expandBaseDirs([]string{filepath.Join(parent, "downloads")})
+	tu.NotOk(t, err)
 }
 
 func TestGetAllGrants57(t *testing.T) {
@@ -71,16 +180,19 @@ func TestGetAllGrants57(t *testing.T) {
 		"CREATE ROUTINE", "CREATE TABLESPACE", "CREATE TEMPORARY TABLES", "CREATE USER",
 		"CREATE VIEW", "DROP", "EVENT", "EXECUTE", "FILE", "GRANT OPTION", "INDEX",
 		"LOCK TABLES", "PROCESS", "REFERENCES", "RELOAD", "REPLICATION CLIENT",
-		"REPLICATION SLAVE", "SHOW DATABASES", "SHOW VIEW", "SHUTDOWN ", "SUPER", "TRIGGER", "USAGE",
+		"REPLICATION SLAVE", "SHOW DATABASES", "SHOW VIEW", "SHUTDOWN", "SUPER", "TRIGGER", "USAGE",
 	}
 
-	sandbox, err := testsandbox.New(opts.mysqlBaseDir)
+	sandbox, err := testsandbox.New(os.Getenv("MYSQL_BASE_DIR"))
 	if err != nil {
 		log.Fatal().Msgf("Cannot start the MySQL sandbox: %s", err)
 	}
+	defer sandbox.RunCleanupActions()
 
-	userGrants, err := sandbox.Grants()
-	tu.IsNil(t, err)
+	// The privileges are discovered from the server so the order depends on the server version
+	userGrants := append([]string{}, sandbox.Grants()...)
+	sort.Strings(userGrants)
+	sort.Strings(want)
 	tu.Equals(t, userGrants, want)
 }
 
@@ -93,7 +205,7 @@ func TestGetAllGrants57(t *testing.T) {
 // 		"CREATE ROUTINE", "CREATE TABLESPACE", "CREATE TEMPORARY TABLES", "CREATE USER",
 // 		"CREATE VIEW", "DROP", "EVENT", "EXECUTE", "FILE", "GRANT OPTION", "INDEX",
 // 		"LOCK TABLES", "PROCESS", "REFERENCES", "RELOAD", "REPLICATION CLIENT",
-// 		"REPLICATION SLAVE", "SHOW DATABASES", "SHOW VIEW", "SHUTDOWN ", "SUPER",
+// 		"REPLICATION SLAVE", "SHOW DATABASES", "SHOW VIEW", "SHUTDOWN", "SUPER",
 // 		"TRIGGER", "USAGE",
 // 		// MySQL 8 Permissible Dynamic Privileges for GRANT and REVOKE
 // 		"BINLOG_ADMIN", "CONNECTION_ADMIN", "ENCRYPTION_KEY_ADMIN", "GROUP_REPLICATION_ADMIN",
@@ -113,7 +225,7 @@ func TestGetAllGrants57(t *testing.T) {
 // 	cfg := tu.GetDSN(t)
 //
 // 	dsn := fmt.Sprintf("%s:%s@%s(%s)/?multiStatements=true", "root", "", "tcp", cfg.Addr)
-// 	templateDSN := fmt.Sprintf("%%s:%%s@%s(%s)/%s?autocommit=0", "tcp", cfg.Addr, "test")
+// 	templateDSN = fmt.Sprintf("%%s:%%s@%s(%s)/%s?autocommit=0", "tcp", cfg.Addr, "test")
 //
 // 	db, err := sql.Open("mysql", dsn)
 // 	db.Exec("CREATE DATABASE IF NOT EXISTS test")
@@ -123,7 +235,7 @@ func TestGetAllGrants57(t *testing.T) {
 // 		"SELECT", "INSERT", "UPDATE", "DELETE", "ALTER", "ALTER ROUTINE", "CREATE", "CREATE ROUTINE",
 // 		"CREATE TABLESPACE", "CREATE TEMPORARY TABLES", "CREATE USER", "CREATE VIEW", "DROP", "EVENT",
 // 		"EXECUTE", "FILE", "GRANT OPTION", "INDEX", "LOCK TABLES", "PROCESS", "REFERENCES", "RELOAD",
-// 		"REPLICATION CLIENT", "REPLICATION SLAVE", "SHOW DATABASES", "SHOW VIEW", "SHUTDOWN ",
+// 		"REPLICATION CLIENT", "REPLICATION SLAVE", "SHOW DATABASES", "SHOW VIEW", "SHUTDOWN",
 // 		"SUPER", "TRIGGER", "USAGE",
 // 	}
 // 	r, i := test(tc, db, templateDSN, grants, 5, stopChan)
@@ -207,7 +319,7 @@ func TestGetAllGrants57(t *testing.T) {
 //
 // 	protocol := "tcp"
 // 	dsn := fmt.Sprintf("root:msandbox@tcp(127.0.0.1:%d)/", port)
-// 	templateDSN := fmt.Sprintf("%%s:%%s@%s(127.0.0.1:%d)/", protocol, port)
+// 	templateDSN = fmt.Sprintf("%%s:%%s@%s(127.0.0.1:%d)/", protocol, port)
 //
 // 	db, err := sql.Open("mysql", dsn)
 // 	if err != nil {